@@ -0,0 +1,485 @@
+// Package apitest provides an in-process fake clash controller
+// for end-to-end tests of api clients and commands.
+package apitest
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/yz3358/clash-ctl/api"
+
+	"github.com/gorilla/websocket"
+)
+
+// Traffic is a sample pushed on the /traffic websocket
+type Traffic struct {
+	Up   int64 `json:"up"`
+	Down int64 `json:"down"`
+}
+
+// Log is an entry pushed on the /logs websocket
+type Log struct {
+	Type    string `json:"type"`
+	Payload string `json:"payload"`
+}
+
+var logLevels = map[string]int{"debug": 0, "info": 1, "warning": 2, "error": 3, "silent": 4}
+
+// Server is a fake controller serving its state over the clash API,
+// the exported fields may be changed by tests between requests
+// while holding Lock.
+type Server struct {
+	*httptest.Server
+	sync.Mutex
+
+	// Secret, if not empty, is required as bearer token
+	Secret  string
+	Version api.Version
+
+	Proxies map[string]api.Proxy
+	// Delays scripts the result of delay tests by proxy name,
+	// proxies missing here fail the test
+	Delays map[string]int
+	// DelaySeries scripts the next delay tests of a proxy, one value per
+	// test (0 failing it), Delays applying once they are used up
+	DelaySeries map[string][]int
+	// NoGroupDelay answers 404 on /group/{name}/delay like older cores
+	NoGroupDelay bool
+	// GroupDelayLimit answers 503 on /group/{name}/delay once that many
+	// group tests ran, 0 for no limit
+	GroupDelayLimit int
+	groupDelays     int
+
+	Configs     map[string]any
+	Connections []api.Connection
+	Rules       []api.Rule
+
+	// Traffic and Logs are streamed once to every websocket client,
+	// one entry every Interval
+	Traffic  []Traffic
+	Logs     []Log
+	Interval time.Duration
+}
+
+// NewServer starts a fake controller with a GLOBAL group
+// and a "Proxy" selector of three nodes
+func NewServer() *Server {
+	s := &Server{
+		Version:     api.Version{Version: "fake"},
+		Proxies:     map[string]api.Proxy{},
+		Delays:      map[string]int{},
+		DelaySeries: map[string][]int{},
+		Configs: map[string]any{
+			"port":         7890,
+			"socks-port":   7891,
+			"mode":         "rule",
+			"log-level":    "info",
+			"allow-lan":    false,
+			"bind-address": "*",
+			"ipv6":         false,
+		},
+		Connections: []api.Connection{},
+		Rules:       []api.Rule{},
+		Interval:    10 * time.Millisecond,
+	}
+
+	s.AddProxy(api.Proxy{Name: "DIRECT", Type: "Direct"})
+	s.AddProxy(api.Proxy{Name: "REJECT", Type: "Reject"})
+	s.AddProxy(api.Proxy{Name: "HK 01", Type: "Shadowsocks"})
+	s.AddProxy(api.Proxy{Name: "JP 02", Type: "Vmess"})
+	s.AddProxy(api.Proxy{Name: "US 03", Type: "Trojan"})
+	s.AddProxy(api.Proxy{Name: "Proxy", Type: "Selector", Now: "HK 01", All: []string{"HK 01", "JP 02", "US 03"}})
+	s.AddProxy(api.Proxy{Name: "GLOBAL", Type: "Selector", Now: "DIRECT", All: []string{"DIRECT", "REJECT", "Proxy", "HK 01", "JP 02", "US 03"}})
+
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	return s
+}
+
+// AddProxy adds or replaces a proxy (or group)
+func (s *Server) AddProxy(p api.Proxy) {
+	s.Lock()
+	defer s.Unlock()
+
+	s.Proxies[p.Name] = p
+}
+
+// SetDelay scripts the delay of proxy, and records it in its history
+func (s *Server) SetDelay(proxy string, delay int) {
+	s.Lock()
+	defer s.Unlock()
+
+	s.Delays[proxy] = delay
+	s.recordDelay(proxy, delay)
+}
+
+// Now returns the proxy selected by group
+func (s *Server) Now(group string) string {
+	s.Lock()
+	defer s.Unlock()
+
+	return s.Proxies[group].Now
+}
+
+// Config returns the runtime config value of key
+func (s *Server) Config(key string) any {
+	s.Lock()
+	defer s.Unlock()
+
+	return s.Configs[key]
+}
+
+// Host and Port return the address of the fake controller
+func (s *Server) Host() string {
+	u, _ := url.Parse(s.URL)
+	return u.Hostname()
+}
+
+func (s *Server) Port() string {
+	u, _ := url.Parse(s.URL)
+	return u.Port()
+}
+
+// nextDelay returns the result of the next delay test of proxy
+func (s *Server) nextDelay(proxy string) (int, bool) {
+	if series := s.DelaySeries[proxy]; len(series) > 0 {
+		s.DelaySeries[proxy] = series[1:]
+		return series[0], series[0] > 0
+	}
+
+	delay, ok := s.Delays[proxy]
+	return delay, ok
+}
+
+func (s *Server) recordDelay(proxy string, delay int) {
+	p, ok := s.Proxies[proxy]
+	if !ok {
+		return
+	}
+
+	p.History = append(p.History, api.History{Delay: delay})
+	s.Proxies[proxy] = p
+}
+
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	s.Lock()
+	secret := s.Secret
+	s.Unlock()
+
+	if secret != "" {
+		auth := r.Header.Get("Authorization")
+		if auth != "Bearer "+secret && r.URL.Query().Get("token") != secret {
+			writeError(w, http.StatusUnauthorized, "Unauthorized")
+			return
+		}
+	}
+
+	var parts []string
+	for _, part := range strings.Split(strings.Trim(r.URL.EscapedPath(), "/"), "/") {
+		p, err := url.PathUnescape(part)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		parts = append(parts, p)
+	}
+
+	switch {
+	case match(parts, "version") && r.Method == http.MethodGet:
+		s.Lock()
+		writeJSON(w, http.StatusOK, s.Version)
+		s.Unlock()
+	case match(parts, "proxies") && r.Method == http.MethodGet:
+		s.Lock()
+		writeJSON(w, http.StatusOK, map[string]any{"proxies": s.Proxies})
+		s.Unlock()
+	case match(parts, "proxies", "*"):
+		s.handleProxy(w, r, parts[1])
+	case match(parts, "proxies", "*", "delay") && r.Method == http.MethodGet:
+		s.handleDelay(w, r, parts[1])
+	case match(parts, "group", "*", "delay") && r.Method == http.MethodGet:
+		s.handleGroupDelay(w, r, parts[1])
+	case match(parts, "configs"):
+		s.handleConfigs(w, r)
+	case match(parts, "connections"), match(parts, "connections", "*"):
+		s.handleConnections(w, r, parts[1:])
+	case match(parts, "rules") && r.Method == http.MethodGet:
+		s.Lock()
+		writeJSON(w, http.StatusOK, map[string]any{"rules": s.Rules})
+		s.Unlock()
+	case match(parts, "traffic"):
+		s.Lock()
+		samples := append([]Traffic{}, s.Traffic...)
+		s.Unlock()
+
+		s.stream(w, r, len(samples), func(i int) any { return samples[i] })
+	case match(parts, "logs"):
+		level, ok := logLevels[r.URL.Query().Get("level")]
+		if !ok {
+			level = logLevels["info"]
+		}
+
+		s.Lock()
+		var logs []Log
+		for _, l := range s.Logs {
+			if logLevels[l.Type] >= level {
+				logs = append(logs, l)
+			}
+		}
+		s.Unlock()
+
+		s.stream(w, r, len(logs), func(i int) any { return logs[i] })
+	default:
+		writeError(w, http.StatusNotFound, "resource not found")
+	}
+}
+
+func (s *Server) handleProxy(w http.ResponseWriter, r *http.Request, name string) {
+	s.Lock()
+	defer s.Unlock()
+
+	proxy, ok := s.Proxies[name]
+	if !ok {
+		writeError(w, http.StatusNotFound, "resource not found")
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, http.StatusOK, proxy)
+	case http.MethodPut:
+		body := struct {
+			Name string `json:"name"`
+		}{}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			writeError(w, http.StatusBadRequest, "Body invalid")
+			return
+		}
+
+		if proxy.Type != "Selector" {
+			writeError(w, http.StatusBadRequest, "Must be a Selector")
+			return
+		}
+
+		found := false
+		for _, n := range proxy.All {
+			found = found || n == body.Name
+		}
+		if !found {
+			writeError(w, http.StatusBadRequest, "Selector update error: proxy not exist")
+			return
+		}
+
+		proxy.Now = body.Name
+		s.Proxies[name] = proxy
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+func (s *Server) handleDelay(w http.ResponseWriter, r *http.Request, name string) {
+	s.Lock()
+	defer s.Unlock()
+
+	if _, ok := s.Proxies[name]; !ok {
+		writeError(w, http.StatusNotFound, "resource not found")
+		return
+	}
+
+	if r.URL.Query().Get("url") == "" || r.URL.Query().Get("timeout") == "" {
+		writeError(w, http.StatusBadRequest, "Body invalid")
+		return
+	}
+
+	delay, ok := s.nextDelay(name)
+	if !ok {
+		s.recordDelay(name, 0)
+		writeError(w, http.StatusServiceUnavailable, "An error occurred in the delay test")
+		return
+	}
+
+	s.recordDelay(name, delay)
+	writeJSON(w, http.StatusOK, map[string]int{"delay": delay})
+}
+
+// handleGroupDelay tests every proxy of a group,
+// the failed ones are left out of the result
+func (s *Server) handleGroupDelay(w http.ResponseWriter, r *http.Request, name string) {
+	s.Lock()
+	defer s.Unlock()
+
+	group, ok := s.Proxies[name]
+	if s.NoGroupDelay || !ok || len(group.All) == 0 {
+		writeError(w, http.StatusNotFound, "resource not found")
+		return
+	}
+
+	if r.URL.Query().Get("url") == "" || r.URL.Query().Get("timeout") == "" {
+		writeError(w, http.StatusBadRequest, "Body invalid")
+		return
+	}
+
+	if s.GroupDelayLimit > 0 && s.groupDelays >= s.GroupDelayLimit {
+		writeError(w, http.StatusServiceUnavailable, "An error occurred in the delay test")
+		return
+	}
+	s.groupDelays++
+
+	result := map[string]int{}
+	for _, proxy := range group.All {
+		delay, ok := s.nextDelay(proxy)
+		s.recordDelay(proxy, delay)
+		if ok {
+			result[proxy] = delay
+		}
+	}
+
+	writeJSON(w, http.StatusOK, result)
+}
+
+func (s *Server) handleConfigs(w http.ResponseWriter, r *http.Request) {
+	s.Lock()
+	defer s.Unlock()
+
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, http.StatusOK, s.Configs)
+	case http.MethodPatch:
+		patch := map[string]any{}
+		if err := json.NewDecoder(r.Body).Decode(&patch); err != nil {
+			writeError(w, http.StatusBadRequest, "Body invalid")
+			return
+		}
+
+		mergeConfigs(s.Configs, patch)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+func mergeConfigs(configs, patch map[string]any) {
+	for key, value := range patch {
+		nested, ok := value.(map[string]any)
+		current, isMap := configs[key].(map[string]any)
+		if ok && isMap {
+			mergeConfigs(current, nested)
+			continue
+		}
+
+		configs[key] = value
+	}
+}
+
+func (s *Server) handleConnections(w http.ResponseWriter, r *http.Request, ids []string) {
+	if websocket.IsWebSocketUpgrade(r) {
+		s.stream(w, r, -1, func(int) any {
+			s.Lock()
+			defer s.Unlock()
+			return s.snapshot()
+		})
+		return
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, http.StatusOK, s.snapshot())
+	case http.MethodDelete:
+		var kept []api.Connection
+		for _, c := range s.Connections {
+			if len(ids) > 0 && c.UUID != ids[0] {
+				kept = append(kept, c)
+			}
+		}
+		s.Connections = kept
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+func (s *Server) snapshot() api.ConnectionSnapshot {
+	snapshot := api.ConnectionSnapshot{Connections: []api.Connection{}}
+	for _, c := range s.Connections {
+		snapshot.UploadTotal += c.UploadTotal
+		snapshot.DownloadTotal += c.DownloadTotal
+		snapshot.Connections = append(snapshot.Connections, c)
+	}
+
+	return snapshot
+}
+
+// stream upgrades to websocket and writes n messages (forever if n < 0)
+// one every Interval, then waits for the client to close
+func (s *Server) stream(w http.ResponseWriter, r *http.Request, n int, message func(i int) any) {
+	upgrader := websocket.Upgrader{CheckOrigin: func(*http.Request) bool { return true }}
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	s.Lock()
+	interval := s.Interval
+	s.Unlock()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for i := 0; n < 0 || i < n; i++ {
+		if err := conn.WriteJSON(message(i)); err != nil {
+			return
+		}
+
+		select {
+		case <-ticker.C:
+		case <-closed:
+			return
+		}
+	}
+
+	<-closed
+}
+
+// match reports whether parts equals pattern, "*" matching any part
+func match(parts []string, pattern ...string) bool {
+	if len(parts) != len(pattern) {
+		return false
+	}
+
+	for i, p := range pattern {
+		if p != "*" && p != parts[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"message": message})
+}
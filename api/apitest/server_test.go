@@ -0,0 +1,53 @@
+package apitest
+
+import (
+	"testing"
+
+	"github.com/yz3358/clash-ctl/common"
+)
+
+func TestStreams(t *testing.T) {
+	srv := NewServer()
+	defer srv.Close()
+
+	srv.Traffic = []Traffic{{Up: 1, Down: 2}, {Up: 3, Down: 4}}
+	srv.Logs = []Log{
+		{Type: "debug", Payload: "hidden"},
+		{Type: "info", Payload: "first"},
+		{Type: "error", Payload: "second"},
+	}
+
+	server := common.Server{Host: srv.Host(), Port: srv.Port()}
+
+	conn, err := common.MakeWebsocket(server, "/traffic")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	for _, expected := range srv.Traffic {
+		var sample Traffic
+		if err := conn.ReadJSON(&sample); err != nil {
+			t.Fatal(err)
+		}
+		if sample != expected {
+			t.Fatalf("expected %+v, got %+v", expected, sample)
+		}
+	}
+
+	logs, err := common.MakeWebsocket(server, "/logs?level=info")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer logs.Close()
+
+	for _, expected := range []string{"first", "second"} {
+		var entry Log
+		if err := logs.ReadJSON(&entry); err != nil {
+			t.Fatal(err)
+		}
+		if entry.Payload != expected {
+			t.Fatalf("expected %s, got %+v", expected, entry)
+		}
+	}
+}
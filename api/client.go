@@ -0,0 +1,68 @@
+// Package api is a typed client of the clash RESTful controller API.
+package api
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/go-resty/resty/v2"
+)
+
+// Client sends requests to a clash controller
+type Client struct {
+	http *resty.Client
+}
+
+// New returns a Client of the controller at baseURL (e.g. http://127.0.0.1:9090),
+// authorized by secret if not empty
+func New(baseURL, secret string) *Client {
+	c := resty.New().SetBaseURL(baseURL)
+	if secret != "" {
+		c.SetAuthToken(secret)
+	}
+
+	return &Client{http: c}
+}
+
+// request starts a request bound to ctx, decoding error bodies into *Error
+func (c *Client) request(ctx context.Context) *resty.Request {
+	return c.http.R().SetContext(ctx).SetError(&Error{})
+}
+
+// check turns the result of a request into an error,
+// responses with an error status become *Error
+func check(resp *resty.Response, err error) error {
+	if err != nil {
+		return err
+	}
+
+	if !resp.IsError() {
+		return nil
+	}
+
+	e, ok := resp.Error().(*Error)
+	if !ok || e == nil {
+		e = &Error{}
+	}
+
+	e.StatusCode = resp.StatusCode()
+	if e.Message == "" {
+		e.Message = http.StatusText(e.StatusCode)
+	}
+
+	return e
+}
+
+type Version struct {
+	Version string `json:"version"`
+	Premium bool   `json:"premium"`
+}
+
+func (c *Client) Version(ctx context.Context) (*Version, error) {
+	result := &Version{}
+	if err := check(c.request(ctx).SetResult(result).Get("/version")); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
@@ -0,0 +1,183 @@
+package api_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/yz3358/clash-ctl/api"
+	"github.com/yz3358/clash-ctl/api/apitest"
+)
+
+func TestProxies(t *testing.T) {
+	srv := apitest.NewServer()
+	defer srv.Close()
+
+	srv.AddProxy(api.Proxy{Name: "HK/01 Premium", Type: "Shadowsocks"})
+	srv.AddProxy(api.Proxy{Name: "Proxy", Type: "Selector", Now: "HK 01", All: []string{"HK 01", "HK/01 Premium"}})
+	srv.SetDelay("HK/01 Premium", 42)
+
+	client := api.New(srv.URL, "")
+	ctx := context.Background()
+
+	proxies, err := client.Proxies(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := proxies["GLOBAL"]; !ok {
+		t.Fatalf("GLOBAL missing in %v", proxies)
+	}
+
+	// names are escaped in the path
+	if err := client.SelectProxy(ctx, "Proxy", "HK/01 Premium"); err != nil {
+		t.Fatal(err)
+	}
+	if now := srv.Now("Proxy"); now != "HK/01 Premium" {
+		t.Fatalf("expected HK/01 Premium selected, got %s", now)
+	}
+
+	proxy, err := client.Proxy(ctx, "HK/01 Premium")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if proxy.LastestDelay() != 42 {
+		t.Fatalf("expected delay 42, got %d", proxy.LastestDelay())
+	}
+
+	delay, err := client.ProxyDelay(ctx, "HK/01 Premium", "http://example.com", time.Second)
+	if err != nil || delay != 42 {
+		t.Fatalf("expected delay 42, got %d, %v", delay, err)
+	}
+}
+
+func TestGroupDelay(t *testing.T) {
+	srv := apitest.NewServer()
+	defer srv.Close()
+	srv.SetDelay("HK 01", 80)
+
+	ctx := context.Background()
+	client := api.New(srv.URL, "")
+
+	delays, err := client.GroupDelay(ctx, "Proxy", "http://example.com", time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(delays) != 1 || delays["HK 01"] != 80 {
+		t.Fatalf("expected only HK 01 with 80ms, got %v", delays)
+	}
+
+	srv.Lock()
+	srv.NoGroupDelay = true
+	srv.Unlock()
+
+	if _, err := client.GroupDelay(ctx, "Proxy", "http://example.com", time.Second); !errors.Is(err, api.ErrNotFound) {
+		t.Fatalf("expected not found on older cores, got %v", err)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	srv := apitest.NewServer()
+	defer srv.Close()
+
+	ctx := context.Background()
+	client := api.New(srv.URL, "")
+
+	_, err := client.Proxy(ctx, "nowhere")
+	if !errors.Is(err, api.ErrNotFound) {
+		t.Fatalf("expected not found, got %v", err)
+	}
+
+	err = client.SelectProxy(ctx, "Proxy", "nowhere")
+	var apiErr *api.Error
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != 400 || apiErr.Message != "Selector update error: proxy not exist" {
+		t.Fatalf("unexpected error %#v", err)
+	}
+
+	// failed delay test
+	if _, err := client.ProxyDelay(ctx, "US 03", "http://example.com", time.Second); !errors.As(err, &apiErr) {
+		t.Fatalf("expected api error, got %v", err)
+	}
+
+	srv.Lock()
+	srv.Secret = "secret"
+	srv.Unlock()
+	if _, err := client.Version(ctx); !errors.Is(err, api.ErrUnauthorized) {
+		t.Fatalf("expected unauthorized, got %v", err)
+	}
+
+	if _, err := api.New(srv.URL, "secret").Version(ctx); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestConfigs(t *testing.T) {
+	srv := apitest.NewServer()
+	defer srv.Close()
+
+	ctx := context.Background()
+	client := api.New(srv.URL, "")
+
+	patch := map[string]any{"mode": "direct", "tun": map[string]any{"enable": true}}
+	if err := client.PatchConfigs(ctx, patch); err != nil {
+		t.Fatal(err)
+	}
+
+	configs, err := client.Configs(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if configs.Mode != "direct" || configs.Port != 7890 {
+		t.Fatalf("unexpected configs %+v", configs)
+	}
+
+	tun, ok := configs.Raw["tun"].(map[string]any)
+	if !ok || tun["enable"] != true {
+		t.Fatalf("expected tun in raw configs, got %v", configs.Raw)
+	}
+}
+
+func TestConnections(t *testing.T) {
+	srv := apitest.NewServer()
+	defer srv.Close()
+
+	srv.Connections = []api.Connection{
+		{UUID: "a", UploadTotal: 1, DownloadTotal: 2, Metadata: api.ConnectionMetadata{Host: "example.com", DstPort: "443"}},
+		{UUID: "b", UploadTotal: 3, DownloadTotal: 4, Metadata: api.ConnectionMetadata{DstIP: "::1", DstPort: "53"}},
+	}
+
+	ctx := context.Background()
+	client := api.New(srv.URL, "")
+
+	snapshot, err := client.Connections(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(snapshot.Connections) != 2 || snapshot.UploadTotal != 4 || snapshot.DownloadTotal != 6 {
+		t.Fatalf("unexpected snapshot %+v", snapshot)
+	}
+
+	if addr := snapshot.Connections[1].Address(); addr != "[::1]:53" {
+		t.Fatalf("unexpected address %s", addr)
+	}
+
+	if err := client.CloseConnection(ctx, "a"); err != nil {
+		t.Fatal(err)
+	}
+
+	snapshot, _ = client.Connections(ctx)
+	if len(snapshot.Connections) != 1 || snapshot.Connections[0].UUID != "b" {
+		t.Fatalf("connection a not closed: %+v", snapshot)
+	}
+
+	if err := client.CloseAllConnections(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	snapshot, _ = client.Connections(ctx)
+	if len(snapshot.Connections) != 0 {
+		t.Fatalf("connections not closed: %+v", snapshot)
+	}
+}
@@ -0,0 +1,47 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+)
+
+// Configs is the runtime config, Raw holds
+// the whole document including keys not listed here
+type Configs struct {
+	Port        int    `json:"port"`
+	SocksPort   int    `json:"socks-port"`
+	RedirPort   int    `json:"redir-port"`
+	TProxyPort  int    `json:"tproxy-port"`
+	MixedPort   int    `json:"mixed-port"`
+	AllowLan    bool   `json:"allow-lan"`
+	BindAddress string `json:"bind-address"`
+	Mode        string `json:"mode"`
+	LogLevel    string `json:"log-level"`
+	IPv6        bool   `json:"ipv6"`
+
+	Raw map[string]any `json:"-"`
+}
+
+func (c *Configs) UnmarshalJSON(buf []byte) error {
+	type configs Configs
+	if err := json.Unmarshal(buf, (*configs)(c)); err != nil {
+		return err
+	}
+
+	return json.Unmarshal(buf, &c.Raw)
+}
+
+func (c *Client) Configs(ctx context.Context) (*Configs, error) {
+	result := &Configs{}
+	if err := check(c.request(ctx).SetResult(result).Get("/configs")); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// PatchConfigs updates the runtime config keys in patch,
+// nested keys are nested maps (e.g. {"tun": {"enable": true}})
+func (c *Client) PatchConfigs(ctx context.Context, patch map[string]any) error {
+	return check(c.request(ctx).SetBody(patch).Patch("/configs"))
+}
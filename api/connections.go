@@ -0,0 +1,70 @@
+package api
+
+import (
+	"context"
+	"net"
+	"time"
+)
+
+type ConnectionMetadata struct {
+	NetWork string `json:"network"`
+	Type    string `json:"type"`
+	SrcIP   string `json:"sourceIP"`
+	DstIP   string `json:"destinationIP"`
+	SrcPort string `json:"sourcePort"`
+	DstPort string `json:"destinationPort"`
+	Host    string `json:"host"`
+}
+
+// Connection is a tracker of the clash core
+type Connection struct {
+	UUID          string             `json:"id"`
+	Metadata      ConnectionMetadata `json:"metadata"`
+	UploadTotal   int64              `json:"upload"`
+	DownloadTotal int64              `json:"download"`
+	Start         string             `json:"start"`
+	Chain         []string           `json:"chains"`
+	Rule          string             `json:"rule"`
+	RulePayload   string             `json:"rulePayload"`
+}
+
+// Address returns the destination host (or ip) with port
+func (c Connection) Address() string {
+	host := c.Metadata.DstIP
+	if c.Metadata.Host != "" {
+		host = c.Metadata.Host
+	}
+
+	return net.JoinHostPort(host, c.Metadata.DstPort)
+}
+
+// StartTime parses Start, returning the zero time if malformed
+func (c Connection) StartTime() time.Time {
+	t, _ := time.Parse(time.RFC3339, c.Start)
+	return t
+}
+
+type ConnectionSnapshot struct {
+	DownloadTotal int64        `json:"downloadTotal"`
+	UploadTotal   int64        `json:"uploadTotal"`
+	Connections   []Connection `json:"connections"`
+}
+
+func (c *Client) Connections(ctx context.Context) (*ConnectionSnapshot, error) {
+	result := &ConnectionSnapshot{
+		Connections: []Connection{},
+	}
+	if err := check(c.request(ctx).SetResult(result).Get("/connections")); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+func (c *Client) CloseConnection(ctx context.Context, id string) error {
+	return check(c.request(ctx).SetPathParam("id", id).Delete("/connections/{id}"))
+}
+
+func (c *Client) CloseAllConnections(ctx context.Context) error {
+	return check(c.request(ctx).Delete("/connections"))
+}
@@ -0,0 +1,30 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+)
+
+// Error is an error response of the controller
+type Error struct {
+	StatusCode int    `json:"-"`
+	Message    string `json:"message"`
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// Is reports whether target is an *Error of the same status code,
+// so errors.Is(err, ErrNotFound) works on any message
+func (e *Error) Is(target error) bool {
+	var t *Error
+	return errors.As(target, &t) && t.StatusCode == e.StatusCode
+}
+
+var (
+	ErrBadRequest   = &Error{StatusCode: http.StatusBadRequest, Message: "bad request"}
+	ErrUnauthorized = &Error{StatusCode: http.StatusUnauthorized, Message: "unauthorized"}
+	ErrNotFound     = &Error{StatusCode: http.StatusNotFound, Message: "resource not found"}
+	ErrTimeout      = &Error{StatusCode: http.StatusRequestTimeout, Message: "timeout"}
+)
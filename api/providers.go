@@ -0,0 +1,74 @@
+package api
+
+import "context"
+
+// VehicleTypeCompatible is the vehicle of the built-in provider
+// holding proxies declared in the config file
+const VehicleTypeCompatible = "Compatible"
+
+type ProxyProvider struct {
+	Name        string  `json:"name"`
+	Type        string  `json:"type"`
+	VehicleType string  `json:"vehicleType"`
+	UpdatedAt   string  `json:"updatedAt"`
+	Proxies     []Proxy `json:"proxies"`
+}
+
+type RuleProvider struct {
+	Name        string `json:"name"`
+	Type        string `json:"type"`
+	Behavior    string `json:"behavior"`
+	RuleCount   int    `json:"ruleCount"`
+	VehicleType string `json:"vehicleType"`
+	UpdatedAt   string `json:"updatedAt"`
+}
+
+// Providers returns the proxy providers by name
+func (c *Client) Providers(ctx context.Context) (map[string]ProxyProvider, error) {
+	result := struct {
+		Providers map[string]ProxyProvider `json:"providers"`
+	}{}
+	if err := check(c.request(ctx).SetResult(&result).Get("/providers/proxies")); err != nil {
+		return nil, err
+	}
+
+	return result.Providers, nil
+}
+
+func (c *Client) Provider(ctx context.Context, name string) (*ProxyProvider, error) {
+	result := &ProxyProvider{}
+	err := check(c.request(ctx).SetResult(result).SetPathParam("name", name).Get("/providers/proxies/{name}"))
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// UpdateProvider refreshes the proxies of provider from its vehicle
+func (c *Client) UpdateProvider(ctx context.Context, name string) error {
+	return check(c.request(ctx).SetPathParam("name", name).Put("/providers/proxies/{name}"))
+}
+
+// HealthCheckProvider tests every proxy of provider,
+// returning once all of them are done
+func (c *Client) HealthCheckProvider(ctx context.Context, name string) error {
+	return check(c.request(ctx).SetPathParam("name", name).Get("/providers/proxies/{name}/healthcheck"))
+}
+
+// RuleProviders returns the rule providers by name
+func (c *Client) RuleProviders(ctx context.Context) (map[string]RuleProvider, error) {
+	result := struct {
+		Providers map[string]RuleProvider `json:"providers"`
+	}{}
+	if err := check(c.request(ctx).SetResult(&result).Get("/providers/rules")); err != nil {
+		return nil, err
+	}
+
+	return result.Providers, nil
+}
+
+// UpdateRuleProvider refreshes the rules of provider from its vehicle
+func (c *Client) UpdateRuleProvider(ctx context.Context, name string) error {
+	return check(c.request(ctx).SetPathParam("name", name).Put("/providers/rules/{name}"))
+}
@@ -0,0 +1,102 @@
+package api
+
+import (
+	"context"
+	"strconv"
+	"time"
+)
+
+type Proxy struct {
+	Name    string    `json:"name"`
+	Type    string    `json:"type"`
+	Now     string    `json:"now"`
+	All     []string  `json:"all"`
+	History []History `json:"history"`
+}
+
+// History is a delay test result of a proxy
+type History struct {
+	Delay int `json:"delay"`
+}
+
+// LastestDelay returns the last delay
+// recorded in history, 0 means the delay is unknown,
+// should be failed to connect.
+func (p Proxy) LastestDelay() int {
+	l := len(p.History)
+	if l == 0 {
+		return 0
+	}
+	return p.History[l-1].Delay
+}
+
+// Proxies returns all proxies and groups by name
+func (c *Client) Proxies(ctx context.Context) (map[string]Proxy, error) {
+	result := struct {
+		Proxies map[string]Proxy `json:"proxies"`
+	}{}
+	if err := check(c.request(ctx).SetResult(&result).Get("/proxies")); err != nil {
+		return nil, err
+	}
+
+	return result.Proxies, nil
+}
+
+func (c *Client) Proxy(ctx context.Context, name string) (*Proxy, error) {
+	result := &Proxy{}
+	err := check(c.request(ctx).SetResult(result).SetPathParam("name", name).Get("/proxies/{name}"))
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// SelectProxy switches the selector group to proxy
+func (c *Client) SelectProxy(ctx context.Context, group, proxy string) error {
+	return check(c.request(ctx).
+		SetPathParam("name", group).
+		SetBody(map[string]string{"name": proxy}).
+		Put("/proxies/{name}"))
+}
+
+// ProxyDelay tests proxy by requesting testURL,
+// returns the delay in milliseconds
+func (c *Client) ProxyDelay(ctx context.Context, proxy, testURL string, timeout time.Duration) (int, error) {
+	result := struct {
+		Delay int `json:"delay"`
+	}{}
+	err := check(c.request(ctx).
+		SetResult(&result).
+		SetPathParam("name", proxy).
+		SetQueryParams(map[string]string{
+			"timeout": strconv.FormatInt(timeout.Milliseconds(), 10),
+			"url":     testURL,
+		}).
+		Get("/proxies/{name}/delay"))
+	if err != nil {
+		return 0, err
+	}
+
+	return result.Delay, nil
+}
+
+// GroupDelay tests every proxy of group at once by requesting testURL,
+// returns the delays in milliseconds by proxy name, failed ones are missing.
+// Cores without the endpoint answer ErrNotFound.
+func (c *Client) GroupDelay(ctx context.Context, group, testURL string, timeout time.Duration) (map[string]int, error) {
+	result := map[string]int{}
+	err := check(c.request(ctx).
+		SetResult(&result).
+		SetPathParam("name", group).
+		SetQueryParams(map[string]string{
+			"timeout": strconv.FormatInt(timeout.Milliseconds(), 10),
+			"url":     testURL,
+		}).
+		Get("/group/{name}/delay"))
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
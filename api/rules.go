@@ -0,0 +1,20 @@
+package api
+
+import "context"
+
+type Rule struct {
+	Type    string `json:"type"`
+	Payload string `json:"payload"`
+	Proxy   string `json:"proxy"`
+}
+
+func (c *Client) Rules(ctx context.Context) ([]Rule, error) {
+	result := struct {
+		Rules []Rule `json:"rules"`
+	}{}
+	if err := check(c.request(ctx).SetResult(&result).Get("/rules")); err != nil {
+		return nil, err
+	}
+
+	return result.Rules, nil
+}
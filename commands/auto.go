@@ -0,0 +1,188 @@
+package commands
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/yz3358/clash-ctl/common"
+
+	"github.com/jedib0t/go-pretty/v6/table"
+	"github.com/jedib0t/go-pretty/v6/text"
+)
+
+// autoFlags are the flags of `proxy auto` besides benchFlags,
+// unset ones fall back to the server config
+var autoFlags = []Flag{
+	{Name: "include", Default: "", Usage: "only pick proxies whose name matches the regex"},
+	{Name: "exclude", Default: "", Usage: "never pick proxies whose name matches the regex"},
+	{Name: "max-delay", Default: time.Duration(0), Usage: "never pick proxies slower than this, 0 for no limit"},
+	{Name: "max-loss", Default: "", Usage: "never pick proxies losing more than this percent of their tests, default auto-max-loss of the server or 0"},
+}
+
+// AutoPolicy decides which proxies `proxy auto` may pick
+type AutoPolicy struct {
+	Include  *regexp.Regexp
+	Exclude  *regexp.Regexp
+	MaxDelay time.Duration
+	// MaxLoss is the highest loss rate accepted, from 0 to 1
+	MaxLoss float64
+}
+
+// autoPolicy resolves the policy on server from the autoFlags in a
+func autoPolicy(server common.Server, a *Args) (*AutoPolicy, error) {
+	include, exclude := server.AutoInclude, server.AutoExclude
+	maxDelay := time.Duration(server.AutoMaxDelay) * time.Millisecond
+	maxLoss := server.AutoMaxLoss
+
+	if v := a.String("include"); v != "" {
+		include = v
+	}
+	if v := a.String("exclude"); v != "" {
+		exclude = v
+	}
+	if v := a.Duration("max-delay"); v > 0 {
+		maxDelay = v
+	}
+	if v := a.String("max-loss"); v != "" {
+		n, err := strconv.Atoi(strings.TrimSuffix(v, "%"))
+		if err != nil {
+			return nil, common.NewUsageError("invalid max loss %s, should be a percent", v)
+		}
+		maxLoss = n
+	}
+	if maxLoss < 0 || maxLoss > 100 {
+		return nil, common.NewUsageError("max loss should be a percent from 0 to 100")
+	}
+
+	policy := &AutoPolicy{MaxDelay: maxDelay, MaxLoss: float64(maxLoss) / 100}
+	var err error
+	if include != "" {
+		if policy.Include, err = regexp.Compile(include); err != nil {
+			return nil, common.NewUsageError("invalid include pattern: %s", err.Error())
+		}
+	}
+	if exclude != "" {
+		if policy.Exclude, err = regexp.Compile(exclude); err != nil {
+			return nil, common.NewUsageError("invalid exclude pattern: %s", err.Error())
+		}
+	}
+
+	return policy, nil
+}
+
+// Allow reports whether the policy accepts proxy, which must have a delay
+func (p AutoPolicy) Allow(proxy Proxy) bool {
+	delay := proxy.LastestDelay()
+	if delay <= 0 {
+		return false
+	}
+
+	if p.MaxDelay > 0 && time.Duration(delay)*time.Millisecond > p.MaxDelay {
+		return false
+	}
+
+	if p.Include != nil && !p.Include.MatchString(proxy.Name) {
+		return false
+	}
+
+	return p.Exclude == nil || !p.Exclude.MatchString(proxy.Name)
+}
+
+// delays returns the median delays of the results whose loss the policy
+// accepts, the other proxies being left out as failed
+func (p AutoPolicy) delays(results []BenchResult) map[string]int {
+	delays := map[string]int{}
+	for _, r := range results {
+		if r.Loss() <= p.MaxLoss {
+			delays[r.Proxy] = r.Median()
+		}
+	}
+
+	return delays
+}
+
+// Best returns the id of the fastest proxy of s the policy accepts, -1 if none
+func (p AutoPolicy) Best(s SelectorTable) int {
+	// proxies are sorted by delay as ProxyList
+	for id, proxy := range s.Proxies {
+		if p.Allow(proxy) {
+			return id
+		}
+	}
+
+	return -1
+}
+
+// autoProxy benchmarks a group then switches it to the fastest proxy
+// the policy accepts, as `proxy auto [group] [flags]`
+func autoProxy(a *Args) error {
+	server, err := defaultServer()
+	if err != nil {
+		return err
+	}
+
+	policy, err := autoPolicy(*server, a)
+	if err != nil {
+		return err
+	}
+
+	group := groupArg(a, 0)
+	s, err := GetSelectorTable(group)
+	if err != nil {
+		return err
+	}
+
+	if err := rememberGroup(group); err != nil {
+		return err
+	}
+
+	results, err := s.Bench(benchOptions(*server, a))
+	if err != nil {
+		return err
+	}
+
+	delays := map[string]int{}
+	for _, r := range results {
+		delays[r.Proxy] = r.Median()
+	}
+	currentSelector = s.withDelays(policy.delays(results))
+
+	id := policy.Best(currentSelector)
+	if id < 0 {
+		return fmt.Errorf("no proxy of group %s passes the policy", currentSelector.Selector.Name)
+	}
+
+	old, best := currentSelector.Selector.Now, currentSelector.Proxies[id]
+	switched := old != best.Name
+	if switched {
+		if err := currentSelector.Use(id); err != nil {
+			return err
+		}
+		currentSelector.Selector.Now = best.Name
+	}
+
+	type entry struct {
+		Group    string `json:"group"`
+		Old      string `json:"old"`
+		OldDelay int    `json:"oldDelay"`
+		New      string `json:"new"`
+		NewDelay int    `json:"newDelay"`
+		Switched bool   `json:"switched"`
+	}
+
+	e := entry{currentSelector.Selector.Name, old, delays[old], best.Name, best.LastestDelay(), switched}
+	if Output != common.FormatTable {
+		return printResult(e, table.Row{"Group", "Old", "Old Delay", "New", "New Delay"}, []table.Row{{e.Group, e.Old, e.OldDelay, e.New, e.NewDelay}}, table.StyleRounded)
+	}
+
+	if !switched {
+		fmt.Printf("%s keeps %s (%s), the fastest allowed\n", e.Group, text.FgGreen.Sprint(e.New), formatDelay(e.NewDelay))
+		return nil
+	}
+
+	fmt.Printf("%s: %s (%s) --> %s (%s)\n", e.Group, e.Old, formatDelay(e.OldDelay), text.FgGreen.Sprint(e.New), formatDelay(e.NewDelay))
+	return nil
+}
@@ -0,0 +1,326 @@
+package commands
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/yz3358/clash-ctl/api"
+	"github.com/yz3358/clash-ctl/common"
+
+	"github.com/jedib0t/go-pretty/v6/table"
+	"github.com/jedib0t/go-pretty/v6/text"
+)
+
+// built-in defaults of `proxy bench`
+var (
+	DefaultBenchURL         = "http://cp.cloudflare.com/generate_204"
+	DefaultBenchTimeout     = 3 * time.Second
+	DefaultBenchRounds      = 3
+	DefaultBenchConcurrency = 8
+)
+
+// benchFlags are the flags of `proxy bench`,
+// unset ones fall back to the server config, then the built-in defaults
+var benchFlags = []Flag{
+	{Name: "url", Default: "", Usage: "test url, default " + DefaultBenchURL},
+	{Name: "timeout", Default: time.Duration(0), Usage: "timeout of a test, default " + DefaultBenchTimeout.String()},
+	{Name: "rounds", Default: 0, Usage: fmt.Sprintf("tests per proxy, default %d", DefaultBenchRounds)},
+	{Name: "concurrency", Default: 0, Usage: fmt.Sprintf("max tests at once, default %d", DefaultBenchConcurrency)},
+}
+
+type BenchOptions struct {
+	URL         string
+	Timeout     time.Duration
+	Rounds      int
+	Concurrency int
+}
+
+// benchOptions resolves the options of a benchmark on server from the benchFlags in a
+func benchOptions(server common.Server, a *Args) BenchOptions {
+	opts := BenchOptions{
+		URL:         DefaultBenchURL,
+		Timeout:     DefaultBenchTimeout,
+		Rounds:      DefaultBenchRounds,
+		Concurrency: DefaultBenchConcurrency,
+	}
+
+	if server.BenchURL != "" {
+		opts.URL = server.BenchURL
+	}
+	if server.BenchTimeout > 0 {
+		opts.Timeout = time.Duration(server.BenchTimeout) * time.Millisecond
+	}
+	if server.BenchRounds > 0 {
+		opts.Rounds = server.BenchRounds
+	}
+	if server.BenchConcurrency > 0 {
+		opts.Concurrency = server.BenchConcurrency
+	}
+
+	if url := a.String("url"); url != "" {
+		opts.URL = url
+	}
+	if timeout := a.Duration("timeout"); timeout > 0 {
+		opts.Timeout = timeout
+	}
+	if a.Has("rounds") && a.Int("rounds") > 0 {
+		opts.Rounds = a.Int("rounds")
+	}
+	if concurrency := a.Int("concurrency"); concurrency > 0 {
+		opts.Concurrency = concurrency
+	}
+
+	return opts
+}
+
+// BenchResult is the delays of a proxy in ms over the rounds of a benchmark
+type BenchResult struct {
+	Proxy   string
+	Samples []int
+	Lost    int
+}
+
+// sorted returns the successful samples in ascending order
+func (r BenchResult) sorted() []int {
+	samples := append([]int{}, r.Samples...)
+	sort.Ints(samples)
+	return samples
+}
+
+func (r BenchResult) Min() int {
+	if len(r.Samples) == 0 {
+		return 0
+	}
+	return r.sorted()[0]
+}
+
+func (r BenchResult) Median() int {
+	return r.percentile(50)
+}
+
+func (r BenchResult) P90() int {
+	return r.percentile(90)
+}
+
+// percentile returns the nearest-rank percentile p of the samples
+func (r BenchResult) percentile(p int) int {
+	samples := r.sorted()
+	if len(samples) == 0 {
+		return 0
+	}
+
+	rank := int(math.Ceil(float64(p) / 100 * float64(len(samples))))
+	if rank < 1 {
+		rank = 1
+	}
+	return samples[rank-1]
+}
+
+// Jitter is the mean difference between successive samples
+func (r BenchResult) Jitter() int {
+	if len(r.Samples) < 2 {
+		return 0
+	}
+
+	sum := 0
+	for i := 1; i < len(r.Samples); i++ {
+		d := r.Samples[i] - r.Samples[i-1]
+		if d < 0 {
+			d = -d
+		}
+		sum += d
+	}
+	return sum / (len(r.Samples) - 1)
+}
+
+// Loss is the rate of failed tests, from 0 to 1
+func (r BenchResult) Loss() float64 {
+	total := len(r.Samples) + r.Lost
+	if total == 0 {
+		return 0
+	}
+	return float64(r.Lost) / float64(total)
+}
+
+// benchResults sorts results by median, lossy ones after the others
+type benchResults []BenchResult
+
+func (l benchResults) Len() int      { return len(l) }
+func (l benchResults) Swap(i, j int) { l[i], l[j] = l[j], l[i] }
+func (l benchResults) Less(i, j int) bool {
+	a, b := l[i].Median(), l[j].Median()
+	if a == 0 {
+		a = math.MaxInt
+	}
+	if b == 0 {
+		b = math.MaxInt
+	}
+
+	if a != b {
+		return a < b
+	}
+	return l[i].Loss() < l[j].Loss()
+}
+
+// Bench tests every proxy of s opts.Rounds times, with the group delay
+// endpoint when the core has it, otherwise proxy by proxy
+func (s SelectorTable) Bench(opts BenchOptions) ([]BenchResult, error) {
+	if s.Selector.Name == "" {
+		return nil, ErrSelectorNotInitialized
+	}
+
+	client, err := defaultClient()
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]BenchResult, len(s.Proxies))
+	for i, proxy := range s.Proxies {
+		results[i].Proxy = proxy.Name
+	}
+
+	err = s.benchGroup(client, opts, results)
+	if errors.Is(err, errNoGroupDelay) {
+		err = s.benchProxies(client, opts, results)
+	}
+
+	if Output == common.FormatTable {
+		fmt.Print("\033[2K\r")
+	}
+
+	return results, err
+}
+
+// errNoGroupDelay is returned by benchGroup when the core lacks the group delay endpoint
+var errNoGroupDelay = errors.New("no group delay endpoint")
+
+// benchGroup tests the whole group of s once per round with GET /group/{name}/delay,
+// it fails with errNoGroupDelay before any test if the core lacks the endpoint,
+// and stops at the first failed round otherwise
+func (s SelectorTable) benchGroup(client *api.Client, opts BenchOptions, results []BenchResult) error {
+	for round := 0; round < opts.Rounds; round++ {
+		if Output == common.FormatTable {
+			fmt.Printf("\033[2K\rbenchmarking %s round %d/%d", s.Selector.Name, round+1, opts.Rounds)
+		}
+
+		delays, err := client.GroupDelay(context.Background(), s.Selector.Name, opts.URL, opts.Timeout)
+		if err != nil && round == 0 && errors.Is(err, api.ErrNotFound) {
+			return errNoGroupDelay
+		} else if err != nil {
+			return fmt.Errorf("benchmark round %d/%d of %s failed: %w", round+1, opts.Rounds, s.Selector.Name, err)
+		}
+
+		for i := range results {
+			if d := delays[results[i].Proxy]; d > 0 {
+				results[i].Samples = append(results[i].Samples, d)
+			} else {
+				results[i].Lost++
+			}
+		}
+	}
+
+	return nil
+}
+
+// benchProxies tests the proxies of s one by one, the rounds of a proxy
+// in order so its samples follow them, running at most opts.Concurrency
+// tests at once
+func (s SelectorTable) benchProxies(client *api.Client, opts BenchOptions, results []BenchResult) error {
+	total, done := len(results)*opts.Rounds, 0
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, opts.Concurrency)
+	for i := range results {
+		wg.Add(1)
+		go func(r *BenchResult) {
+			defer wg.Done()
+
+			for round := 0; round < opts.Rounds; round++ {
+				sem <- struct{}{}
+				delay, err := client.ProxyDelay(context.Background(), r.Proxy, opts.URL, opts.Timeout)
+				<-sem
+
+				mu.Lock()
+				if err != nil || delay <= 0 {
+					r.Lost++
+				} else {
+					r.Samples = append(r.Samples, delay)
+				}
+
+				done++
+				if Output == common.FormatTable {
+					fmt.Printf("\033[2K\rbenchmarking %s %d/%d", s.Selector.Name, done, total)
+				}
+				mu.Unlock()
+			}
+		}(&results[i])
+	}
+
+	wg.Wait()
+	return nil
+}
+
+// BenchMark benchmarks s, then shows the summary with the ids
+// of the selector table sorted by the new delays
+func (s SelectorTable) BenchMark(opts BenchOptions) error {
+	results, err := s.Bench(opts)
+	if err != nil {
+		return err
+	}
+
+	// the median delays become the latest ones of the table
+	delays := map[string]int{}
+	for _, r := range results {
+		delays[r.Proxy] = r.Median()
+	}
+	refreshed := s.withDelays(delays)
+	currentSelector = refreshed
+
+	sort.Stable(benchResults(results))
+
+	type entry struct {
+		ID      int     `json:"id"`
+		Name    string  `json:"name"`
+		Min     int     `json:"min"`
+		Median  int     `json:"median"`
+		P90     int     `json:"p90"`
+		Jitter  int     `json:"jitter"`
+		Loss    float64 `json:"loss"`
+		Samples []int   `json:"samples"`
+	}
+
+	entries := []entry{}
+	rows := []table.Row{}
+	for _, r := range results {
+		id := refreshed.indexOf(r.Proxy)
+		entries = append(entries, entry{id, r.Proxy, r.Min(), r.Median(), r.P90(), r.Jitter(), r.Loss(), r.Samples})
+
+		loss := fmt.Sprintf("%.0f%%", r.Loss()*100)
+		if r.Lost > 0 {
+			loss = text.FgRed.Sprint(loss)
+		}
+
+		jitter := "-"
+		if len(r.Samples) > 1 {
+			jitter = fmt.Sprintf("%dms", r.Jitter())
+		}
+
+		rows = append(rows, table.Row{id, r.Proxy, formatDelay(r.Min()), formatDelay(r.Median()), formatDelay(r.P90()), jitter, loss})
+	}
+
+	header := table.Row{"Id", "Proxy Name", "Min", "Median", "P90", "Jitter", "Loss"}
+	if err := printResult(entries, header, rows, table.StyleRounded); err != nil {
+		return err
+	}
+
+	if Output == common.FormatTable {
+		fmt.Printf("%s, %d rounds of %s, timeout %s\n", refreshed.Selector.Name, opts.Rounds, opts.URL, opts.Timeout)
+	}
+	return nil
+}
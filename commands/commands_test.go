@@ -0,0 +1,1224 @@
+package commands
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/yz3358/clash-ctl/api"
+	"github.com/yz3358/clash-ctl/api/apitest"
+	"github.com/yz3358/clash-ctl/common"
+)
+
+// setup starts a fake controller selected as server "fake"
+// in the config file of a temporary HOME
+func setup(t *testing.T) *apitest.Server {
+	t.Helper()
+
+	t.Setenv("HOME", t.TempDir())
+	if err := common.Init(); err != nil {
+		t.Fatal(err)
+	}
+
+	srv := apitest.NewServer()
+	t.Cleanup(srv.Close)
+
+	cfg := &common.Config{
+		Servers: map[string]common.Server{
+			"fake":  {Host: srv.Host(), Port: srv.Port()},
+			"other": {Host: "127.0.0.1", Port: "1"},
+		},
+		Selected: "fake",
+	}
+	if err := common.SaveCfg(cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	resetSelector()
+	Output = common.FormatTable
+	resetCompletionCache()
+	t.Cleanup(func() {
+		resetSelector()
+		Output = common.FormatTable
+		resetCompletionCache()
+	})
+
+	return srv
+}
+
+// capture returns what fn prints to stdout
+func capture(t *testing.T, fn func() error) (string, error) {
+	t.Helper()
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stdout := os.Stdout
+	os.Stdout = w
+	defer func() { os.Stdout = stdout }()
+
+	out := make(chan string)
+	go func() {
+		buf, _ := io.ReadAll(r)
+		out <- string(buf)
+	}()
+
+	err = fn()
+	w.Close()
+	return <-out, err
+}
+
+func isUsageError(err error) bool {
+	var usageErr *common.UsageError
+	return errors.As(err, &usageErr)
+}
+
+func TestProxyLs(t *testing.T) {
+	srv := setup(t)
+	srv.SetDelay("JP 02", 80)
+	srv.SetDelay("HK 01", 200)
+
+	out, err := capture(t, func() error { return Execute([]string{"proxy", "ls"}) })
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// sorted by delay, unknown delay last
+	jp, hk, us := strings.Index(out, "JP 02"), strings.Index(out, "HK 01"), strings.Index(out, "US 03")
+	if jp < 0 || !(jp < hk && hk < us) {
+		t.Fatalf("proxies not sorted by delay:\n%s", out)
+	}
+}
+
+func TestProxyLsJSON(t *testing.T) {
+	setup(t)
+	Output = common.FormatJSON
+
+	out, err := capture(t, func() error { return Execute([]string{"proxy", "ls"}) })
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var entries []struct {
+		ID       int    `json:"id"`
+		Group    string `json:"group"`
+		Name     string `json:"name"`
+		Selected bool   `json:"selected"`
+	}
+	if err := json.Unmarshal([]byte(out), &entries); err != nil {
+		t.Fatalf("invalid json %q: %s", out, err)
+	}
+
+	if len(entries) != 3 || entries[0].Group != "Proxy" {
+		t.Fatalf("unexpected entries %+v", entries)
+	}
+}
+
+func TestProxyLsCutsOnlyTable(t *testing.T) {
+	setup(t)
+	defer func(n int) { maxRendered = n }(maxRendered)
+	maxRendered = 0
+
+	out, err := capture(t, func() error { return Execute([]string{"proxy", "ls"}) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out, "HK 01") || strings.Contains(out, "JP 02") {
+		t.Fatalf("expected a single table row:\n%s", out)
+	}
+
+	Output = common.FormatJSON
+	out, err = capture(t, func() error { return Execute([]string{"proxy", "ls"}) })
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var entries []struct {
+		Name string `json:"name"`
+	}
+	if err := json.Unmarshal([]byte(out), &entries); err != nil {
+		t.Fatalf("invalid json %q: %s", out, err)
+	}
+	if len(entries) != 3 {
+		t.Fatalf("expected every proxy in json, got %+v", entries)
+	}
+}
+
+func TestProxyGroups(t *testing.T) {
+	srv := setup(t)
+	srv.AddProxy(api.Proxy{Name: "Streaming", Type: "Selector", Now: "US 03", All: []string{"US 03", "JP 02"}})
+
+	out, err := capture(t, func() error { return Execute([]string{"proxy", "groups"}) })
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, group := range []string{"Proxy <-", "Streaming", "GLOBAL"} {
+		if !strings.Contains(out, group) {
+			t.Errorf("group %s not listed:\n%s", group, out)
+		}
+	}
+}
+
+func TestProxyUse(t *testing.T) {
+	srv := setup(t)
+	srv.SetDelay("US 03", 10)
+
+	// fastest first
+	if _, err := capture(t, func() error { return Execute([]string{"proxy", "use", "0"}) }); err != nil {
+		t.Fatal(err)
+	}
+	if now := srv.Now("Proxy"); now != "US 03" {
+		t.Fatalf("expected US 03 selected, got %s", now)
+	}
+
+	if _, err := capture(t, func() error { return Execute([]string{"proxy", "use", "JP 02"}) }); err != nil {
+		t.Fatal(err)
+	}
+	if now := srv.Now("Proxy"); now != "JP 02" {
+		t.Fatalf("expected JP 02 selected, got %s", now)
+	}
+
+	_, err := capture(t, func() error { return Execute([]string{"proxy", "use", "nowhere"}) })
+	if !isUsageError(err) {
+		t.Fatalf("expected usage error, got %v", err)
+	}
+}
+
+func TestProxyUseAfterServerSwitch(t *testing.T) {
+	srv := setup(t)
+
+	b := apitest.NewServer()
+	t.Cleanup(b.Close)
+	b.AddProxy(api.Proxy{Name: "SG 04", Type: "Vmess"})
+	b.AddProxy(api.Proxy{Name: "TW 05", Type: "Trojan"})
+	b.AddProxy(api.Proxy{Name: "Proxy", Type: "Selector", Now: "SG 04", All: []string{"SG 04", "TW 05"}})
+
+	cfg, err := common.ReadCfg()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.Servers["b"] = common.Server{Host: b.Host(), Port: b.Port()}
+	if err := common.SaveCfg(cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, args := range [][]string{{"proxy", "ls"}, {"use", "b"}, {"proxy", "use", "1"}} {
+		if _, err := capture(t, func() error { return Execute(args) }); err != nil {
+			t.Fatalf("%v: %v", args, err)
+		}
+	}
+
+	if now := b.Now("Proxy"); now != "TW 05" {
+		t.Fatalf("expected TW 05 selected on b, got %s", now)
+	}
+	if now := srv.Now("Proxy"); now != "HK 01" {
+		t.Fatalf("expected the first server untouched, got %s", now)
+	}
+}
+
+func TestProxyUseResolverAfterServerSwitch(t *testing.T) {
+	setup(t)
+
+	b := apitest.NewServer()
+	t.Cleanup(b.Close)
+	b.AddProxy(api.Proxy{Name: "SG 04", Type: "Vmess"})
+	b.AddProxy(api.Proxy{Name: "Proxy", Type: "Selector", Now: "SG 04", All: []string{"SG 04"}})
+
+	if _, err := capture(t, func() error { return Execute([]string{"proxy", "ls"}) }); err != nil {
+		t.Fatal(err)
+	}
+
+	// selected outside of `use`, so the loaded table is not reset
+	cfg, err := common.ReadCfg()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.Servers["b"] = common.Server{Host: b.Host(), Port: b.Port()}
+	cfg.Selected = "b"
+	if err := common.SaveCfg(cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	if nodes := ProxyUseResolver([]string{""}); len(nodes) == 0 || nodes[0].Text != "SG 04" {
+		t.Fatalf("expected the proxies of b, got %+v", nodes)
+	}
+}
+
+func TestFuzzyScore(t *testing.T) {
+	ranked := []string{"tokyo2", "Tokyo2 Premium", "JP tokyo2", "JP Tokyo 2"}
+	for i := 1; i < len(ranked); i++ {
+		a, b := fuzzyScore("tokyo2", ranked[i-1]), fuzzyScore("tokyo2", ranked[i])
+		if a <= b {
+			t.Errorf("expected %q (%d) to score above %q (%d)", ranked[i-1], a, ranked[i], b)
+		}
+	}
+
+	// the substring penalty counts runes, a flag emoji being 2 of them
+	if a, b := fuzzyScore("tokyo", "🇯🇵 Tokyo 02"), fuzzyScore("tokyo", "JP Tokyo 02"); a != b {
+		t.Errorf("expected the emoji flag to weigh as 2 letters, got %d and %d", a, b)
+	}
+
+	if score := fuzzyScore("tokyo2", "HK 01"); score != 0 {
+		t.Errorf("expected no match, got %d", score)
+	}
+}
+
+func TestProxyUseFuzzy(t *testing.T) {
+	srv := setup(t)
+	srv.AddProxy(api.Proxy{Name: "JP tokyo2", Type: "Shadowsocks"})
+	srv.AddProxy(api.Proxy{Name: "JP tokyo3", Type: "Shadowsocks"})
+	srv.AddProxy(api.Proxy{Name: "Proxy", Type: "Selector", Now: "HK 01", All: []string{"HK 01", "JP 02", "JP tokyo2", "JP tokyo3"}})
+
+	if _, err := capture(t, func() error { return Execute([]string{"proxy", "use", "TOKYO2"}) }); err != nil {
+		t.Fatal(err)
+	}
+	if now := srv.Now("Proxy"); now != "JP tokyo2" {
+		t.Fatalf("expected JP tokyo2 selected, got %s", now)
+	}
+
+	out, err := capture(t, func() error { return Execute([]string{"proxy", "use", "jp"}) })
+	if !isUsageError(err) {
+		t.Fatalf("expected usage error of ambiguous name, got %v", err)
+	}
+	for _, name := range []string{"JP 02", "JP tokyo2", "JP tokyo3"} {
+		if !strings.Contains(out, name) {
+			t.Errorf("candidate %s not shown:\n%s", name, out)
+		}
+	}
+	if strings.Contains(out, "HK 01") {
+		t.Errorf("unexpected candidate HK 01:\n%s", out)
+	}
+}
+
+func TestProxyUseResolver(t *testing.T) {
+	srv := setup(t)
+	srv.SetDelay("US 03", 10)
+
+	nodes := ProxyUseResolver([]string{""})
+	if len(nodes) < 3 || nodes[0].Text != "US 03" || nodes[0].Description != "id 0, 10ms" {
+		t.Fatalf("expected US 03 first with its delay, got %+v", nodes)
+	}
+
+	groups := map[string]bool{}
+	for _, n := range nodes[3:] {
+		groups[n.Text] = true
+	}
+	if !groups["Proxy"] || !groups["GLOBAL"] {
+		t.Errorf("expected groups after proxies, got %+v", nodes)
+	}
+
+	// cached until the proxies change
+	srv.AddProxy(api.Proxy{Name: "Proxy", Type: "Selector", Now: "HK 01", All: []string{"HK 01"}})
+	if nodes := ProxyUseResolver([]string{"Proxy", ""}); len(nodes) != 3 {
+		t.Errorf("expected cached proxies, got %+v", nodes)
+	}
+
+	resetCompletionCache()
+	if nodes := ProxyUseResolver([]string{"Proxy", ""}); len(nodes) != 1 || nodes[0].Description != "id 0, -, now" {
+		t.Errorf("expected refreshed proxies, got %+v", nodes)
+	}
+}
+
+func TestPolicyResolverCached(t *testing.T) {
+	srv := setup(t)
+	srv.Lock()
+	srv.Rules = []api.Rule{{Type: "DOMAIN", Payload: "a.com", Proxy: "Proxy"}}
+	srv.Unlock()
+
+	if nodes := PolicyResolver(); len(nodes) != 1 || nodes[0].Text != "Proxy" {
+		t.Fatalf("expected the Proxy policy, got %+v", nodes)
+	}
+
+	srv.Lock()
+	srv.Rules = append(srv.Rules, api.Rule{Type: "MATCH", Proxy: "DIRECT"})
+	srv.Unlock()
+	if nodes := PolicyResolver(); len(nodes) != 1 {
+		t.Errorf("expected cached rules, got %+v", nodes)
+	}
+
+	resetCompletionCache()
+	if nodes := PolicyResolver(); len(nodes) != 2 {
+		t.Errorf("expected refreshed rules, got %+v", nodes)
+	}
+}
+
+func TestProxyUseGroupRemembered(t *testing.T) {
+	srv := setup(t)
+	srv.AddProxy(api.Proxy{Name: "Streaming", Type: "Selector", Now: "US 03", All: []string{"US 03", "JP 02"}})
+
+	if _, err := capture(t, func() error { return Execute([]string{"proxy", "use", "Streaming", "JP 02"}) }); err != nil {
+		t.Fatal(err)
+	}
+	if now := srv.Now("Streaming"); now != "JP 02" {
+		t.Fatalf("expected JP 02 selected, got %s", now)
+	}
+
+	cfg, err := common.ReadCfg()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if group := cfg.Servers["fake"].Group; group != "Streaming" {
+		t.Fatalf("expected default group Streaming, got %q", group)
+	}
+}
+
+func TestProxySetAPIError(t *testing.T) {
+	setup(t)
+
+	_, err := capture(t, func() error { return Execute([]string{"proxy", "set", "Proxy", "nowhere"}) })
+
+	var apiErr *api.Error
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != 400 {
+		t.Fatalf("expected api error 400, got %v", err)
+	}
+}
+
+func TestProxyBench(t *testing.T) {
+	srv := setup(t)
+	srv.Lock()
+	srv.Delays["HK 01"] = 300
+	srv.Delays["JP 02"] = 50
+	srv.Unlock()
+
+	out, err := capture(t, func() error { return Execute([]string{"proxy", "bench", "--rounds", "2"}) })
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(out, "50ms") || !strings.Contains(out, "300ms") {
+		t.Fatalf("delays not shown:\n%s", out)
+	}
+	if !strings.Contains(out, "100%") || !strings.Contains(out, "2 rounds") {
+		t.Fatalf("loss or rounds not shown:\n%s", out)
+	}
+
+	if d := currentSelector.Proxies[0]; d.Name != "JP 02" || d.LastestDelay() != 50 {
+		t.Fatalf("expected JP 02 first after bench, got %+v", d)
+	}
+}
+
+func TestProxyBenchFallback(t *testing.T) {
+	srv := setup(t)
+	srv.Lock()
+	srv.NoGroupDelay = true
+	srv.Delays["US 03"] = 70
+	srv.Unlock()
+
+	if _, err := capture(t, func() error { return Execute([]string{"proxy", "bench", "--rounds", "1"}) }); err != nil {
+		t.Fatal(err)
+	}
+
+	if d := currentSelector.Proxies[0]; d.Name != "US 03" || d.LastestDelay() != 70 {
+		t.Fatalf("expected US 03 first after bench, got %+v", d)
+	}
+	if d := currentSelector.Proxies[1]; d.LastestDelay() != 0 {
+		t.Fatalf("expected failed proxies without delay, got %+v", d)
+	}
+}
+
+func TestProxyBenchJSON(t *testing.T) {
+	srv := setup(t)
+	srv.SetDelay("HK 01", 120)
+	Output = common.FormatJSON
+
+	// defaults of the server config, overridden by flags
+	cfg, err := common.ReadCfg()
+	if err != nil {
+		t.Fatal(err)
+	}
+	fake := cfg.Servers["fake"]
+	fake.BenchRounds = 4
+	fake.BenchURL = "http://example.com"
+	cfg.Servers["fake"] = fake
+	if err := common.SaveCfg(cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := capture(t, func() error { return Execute([]string{"proxy", "bench", "--concurrency", "1"}) })
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var entries []struct {
+		Name    string  `json:"name"`
+		Median  int     `json:"median"`
+		Loss    float64 `json:"loss"`
+		Samples []int   `json:"samples"`
+	}
+	if err := json.Unmarshal([]byte(out), &entries); err != nil {
+		t.Fatalf("invalid json: %v\n%s", err, out)
+	}
+
+	if len(entries) != 3 || entries[0].Name != "HK 01" || entries[0].Median != 120 || len(entries[0].Samples) != 4 {
+		t.Fatalf("unexpected result %+v", entries)
+	}
+	if entries[1].Loss != 1 {
+		t.Fatalf("expected untested proxies lost, got %+v", entries[1])
+	}
+}
+
+func TestProxyAuto(t *testing.T) {
+	srv := setup(t)
+	srv.Lock()
+	srv.Delays["HK 01"] = 300
+	srv.Delays["JP 02"] = 50
+	srv.Delays["US 03"] = 100
+	srv.Unlock()
+
+	out, err := capture(t, func() error { return Execute([]string{"proxy", "auto", "--exclude", "^JP"}) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if now := srv.Now("Proxy"); now != "US 03" {
+		t.Fatalf("expected US 03 selected, got %s", now)
+	}
+	if !strings.Contains(out, "HK 01") || !strings.Contains(out, "US 03") {
+		t.Fatalf("old and new choice not reported:\n%s", out)
+	}
+
+	if _, err := capture(t, func() error { return Execute([]string{"proxy", "auto", "--max-delay", "20ms"}) }); err == nil {
+		t.Fatal("expected error when no proxy passes the policy")
+	}
+	if now := srv.Now("Proxy"); now != "US 03" {
+		t.Fatalf("expected US 03 kept, got %s", now)
+	}
+
+	// policy of the server config
+	cfg, err := common.ReadCfg()
+	if err != nil {
+		t.Fatal(err)
+	}
+	fake := cfg.Servers["fake"]
+	fake.AutoInclude = "HK|JP"
+	cfg.Servers["fake"] = fake
+	if err := common.SaveCfg(cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	Output = common.FormatJSON
+	out, err = capture(t, func() error { return Execute([]string{"proxy", "auto", "Proxy"}) })
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var result struct {
+		Old      string `json:"old"`
+		New      string `json:"new"`
+		NewDelay int    `json:"newDelay"`
+		Switched bool   `json:"switched"`
+	}
+	if err := json.Unmarshal([]byte(out), &result); err != nil {
+		t.Fatalf("invalid json: %v\n%s", err, out)
+	}
+	if result.Old != "US 03" || result.New != "JP 02" || result.NewDelay != 50 || !result.Switched {
+		t.Fatalf("unexpected result %+v", result)
+	}
+}
+
+func TestProxyAutoMaxLoss(t *testing.T) {
+	srv := setup(t)
+	srv.Lock()
+	srv.Delays["HK 01"] = 300
+	srv.Delays["JP 02"] = 50
+	srv.Delays["US 03"] = 100
+	srv.DelaySeries["JP 02"] = []int{50, 0, 50}
+	srv.Unlock()
+
+	// the fastest median lost a test of three
+	if _, err := capture(t, func() error { return Execute([]string{"proxy", "auto", "--rounds", "3"}) }); err != nil {
+		t.Fatal(err)
+	}
+	if now := srv.Now("Proxy"); now != "US 03" {
+		t.Fatalf("expected US 03 selected over the lossy JP 02, got %s", now)
+	}
+
+	srv.Lock()
+	srv.DelaySeries["JP 02"] = []int{50, 0, 50}
+	srv.Unlock()
+	if _, err := capture(t, func() error { return Execute([]string{"proxy", "auto", "--rounds", "3", "--max-loss", "50"}) }); err != nil {
+		t.Fatal(err)
+	}
+	if now := srv.Now("Proxy"); now != "JP 02" {
+		t.Fatalf("expected JP 02 selected within the max loss, got %s", now)
+	}
+
+	for _, v := range []string{"150", "-1", "some"} {
+		if _, err := capture(t, func() error { return Execute([]string{"proxy", "auto", "--max-loss", v}) }); !isUsageError(err) {
+			t.Fatalf("%s: expected a usage error, got %v", v, err)
+		}
+	}
+
+	out, err := capture(t, func() error { return Execute([]string{"help", "proxy", "auto"}) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(out, "default -1") || !strings.Contains(out, "--max-loss ") {
+		t.Fatalf("unexpected max-loss help:\n%s", out)
+	}
+}
+
+func TestWatchdog(t *testing.T) {
+	srv := setup(t)
+	srv.Lock()
+	srv.Delays["JP 02"] = 50
+	srv.Delays["US 03"] = 80
+	srv.Unlock()
+
+	client, err := defaultClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	log := &strings.Builder{}
+	w := &watchdog{
+		groups:     []string{"Proxy"},
+		states:     map[string]*watchState{},
+		opts:       BenchOptions{URL: "http://example.com", Timeout: time.Second, Rounds: 1, Concurrency: 2},
+		failures:   2,
+		hysteresis: 2,
+		cooldown:   time.Hour,
+		log:        log,
+	}
+
+	// HK 01 fails twice before failing over
+	capture(t, func() error { w.check(client); return nil })
+	if now := srv.Now("Proxy"); now != "HK 01" {
+		t.Fatalf("expected no switch after one failure, got %s", now)
+	}
+
+	capture(t, func() error { w.check(client); return nil })
+	if now := srv.Now("Proxy"); now != "JP 02" {
+		t.Fatalf("expected failover to JP 02, got %s", now)
+	}
+	if !strings.Contains(log.String(), "Proxy: HK 01 --> JP 02 (50ms)") {
+		t.Fatalf("switch not logged: %q", log.String())
+	}
+
+	// no switch back within the cooldown
+	srv.Lock()
+	delete(srv.Delays, "JP 02")
+	srv.Unlock()
+
+	for i := 0; i < 3; i++ {
+		capture(t, func() error { w.check(client); return nil })
+	}
+	if now := srv.Now("Proxy"); now != "JP 02" {
+		t.Fatalf("expected no switch in cooldown, got %s", now)
+	}
+
+	w.cooldown = 0
+	capture(t, func() error { w.check(client); return nil })
+	if now := srv.Now("Proxy"); now != "US 03" || len(w.switches) != 2 {
+		t.Fatalf("expected failover to US 03, got %s after %d switches", now, len(w.switches))
+	}
+}
+
+func TestWatchdogHysteresis(t *testing.T) {
+	srv := setup(t)
+	srv.Lock()
+	srv.Delays["JP 02"] = 50
+	// flaps on the second check
+	srv.DelaySeries["JP 02"] = []int{50, 0}
+	srv.Unlock()
+
+	client, err := defaultClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := &watchdog{
+		groups:     []string{"Proxy"},
+		states:     map[string]*watchState{},
+		opts:       BenchOptions{URL: "http://example.com", Timeout: time.Second, Rounds: 1, Concurrency: 2},
+		failures:   1,
+		hysteresis: 2,
+	}
+
+	// passes 1, 0, 1, then 2 checks in a row
+	for i, want := range []string{"HK 01", "HK 01", "HK 01", "JP 02"} {
+		capture(t, func() error { w.check(client); return nil })
+		if now := srv.Now("Proxy"); now != want {
+			t.Fatalf("check %d: expected %s selected, got %s", i+1, want, now)
+		}
+	}
+}
+
+func TestBenchResult(t *testing.T) {
+	r := BenchResult{Samples: []int{120, 100, 300, 110, 130, 105, 115, 125, 140, 150}, Lost: 2}
+
+	if r.Min() != 100 || r.Median() != 120 || r.P90() != 150 {
+		t.Errorf("unexpected min/median/p90 %d/%d/%d", r.Min(), r.Median(), r.P90())
+	}
+
+	// (20 + 200 + 190 + 20 + 25 + 10 + 10 + 15 + 10) / 9
+	if jitter := r.Jitter(); jitter != 55 {
+		t.Errorf("unexpected jitter %d", jitter)
+	}
+
+	if loss := r.Loss(); loss != 2.0/12 {
+		t.Errorf("unexpected loss %f", loss)
+	}
+}
+
+func TestBenchProxiesRoundOrder(t *testing.T) {
+	srv := setup(t)
+	srv.Lock()
+	srv.NoGroupDelay = true
+	srv.DelaySeries["HK 01"] = []int{300, 0, 100, 200}
+	srv.DelaySeries["JP 02"] = []int{50, 60, 70, 80}
+	srv.Unlock()
+
+	s, err := GetSelectorTable("Proxy")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	results, err := s.Bench(BenchOptions{URL: "http://example.com", Timeout: time.Second, Rounds: 4, Concurrency: 8})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	byName := map[string]BenchResult{}
+	for _, r := range results {
+		byName[r.Proxy] = r
+	}
+
+	if r := byName["HK 01"]; fmt.Sprint(r.Samples) != "[300 100 200]" || r.Lost != 1 || r.Jitter() != 150 {
+		t.Errorf("expected HK 01 samples in round order, got %+v", r)
+	}
+	if r := byName["JP 02"]; fmt.Sprint(r.Samples) != "[50 60 70 80]" {
+		t.Errorf("expected JP 02 samples in round order, got %+v", r)
+	}
+}
+
+func TestBenchGroupRoundFailure(t *testing.T) {
+	srv := setup(t)
+	srv.Lock()
+	srv.Delays["HK 01"] = 100
+	srv.GroupDelayLimit = 1
+	srv.Unlock()
+
+	s, err := GetSelectorTable("Proxy")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a later round failing is an error, not a lost test
+	var apiErr *api.Error
+	_, err = s.Bench(BenchOptions{URL: "http://example.com", Timeout: time.Second, Rounds: 3, Concurrency: 8})
+	if !errors.As(err, &apiErr) || !strings.Contains(err.Error(), "round 2/3") {
+		t.Fatalf("expected the api error of round 2, got %v", err)
+	}
+}
+
+func TestTrafficStats(t *testing.T) {
+	stats := newTrafficStats(3)
+	for _, down := range []int64{100, 400, 200, 800} {
+		stats.add(TrafficSample{Up: down / 2, Down: down})
+	}
+
+	if len(stats.samples) != 3 || stats.count != 4 {
+		t.Fatalf("expected a window of 3 out of 4 samples, got %d of %d", len(stats.samples), stats.count)
+	}
+	if stats.current().Down != 800 || stats.peak.Down != 800 || stats.total.Down != 1500 || stats.average().Down != 375 {
+		t.Fatalf("unexpected download stats %+v, %+v, %+v", stats.current(), stats.peak, stats.total)
+	}
+
+	if line := sparkline([]int64{0, 4, 7}, 5); line != "  ▁▅█" {
+		t.Fatalf("unexpected sparkline %q", line)
+	}
+}
+
+func TestTrafficDuration(t *testing.T) {
+	srv := setup(t)
+	srv.Lock()
+	srv.Traffic = []apitest.Traffic{{Up: 10, Down: 100}, {Up: 30, Down: 300}, {Up: 20, Down: 200}}
+	srv.Unlock()
+	Output = common.FormatJSON
+
+	out, err := capture(t, func() error { return Execute([]string{"traffic", "--duration", "200ms"}) })
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var entries []struct {
+		Direction string `json:"direction"`
+		Current   int64  `json:"current"`
+		Peak      int64  `json:"peak"`
+		Average   int64  `json:"average"`
+		Total     int64  `json:"total"`
+	}
+	if err := json.Unmarshal([]byte(out), &entries); err != nil {
+		t.Fatalf("invalid json %q: %v", out, err)
+	}
+
+	if len(entries) != 2 || entries[0].Direction != "download" {
+		t.Fatalf("unexpected summary %+v", entries)
+	}
+	if d := entries[0]; d.Current != 200 || d.Peak != 300 || d.Average != 200 || d.Total != 600 {
+		t.Fatalf("unexpected download summary %+v", d)
+	}
+}
+
+func TestExporter(t *testing.T) {
+	srv := setup(t)
+	srv.SetDelay("JP 02", 80)
+	srv.SetDelay("US 03", 0)
+	srv.Lock()
+	srv.Traffic = []apitest.Traffic{{Up: 10, Down: 100}}
+	srv.Connections = []api.Connection{{UUID: "1", UploadTotal: 5, DownloadTotal: 50}}
+	srv.Unlock()
+
+	cfg, err := common.ReadCfg()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e := newExporter(cfg.Servers, time.Second)
+	e.start()
+	defer e.stop()
+
+	for i := 0; i < 100; i++ {
+		if _, ok := e.traffic["fake"].latest(); ok {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	out := rec.Body.String()
+
+	for _, line := range []string{
+		"# TYPE clash_up gauge",
+		`clash_up{server="fake"} 1`,
+		`clash_up{server="other"} 0`,
+		`clash_info{server="fake",version="fake"} 1`,
+		`clash_mode{server="fake",mode="rule"} 1`,
+		`clash_mode{server="fake",mode="global"} 0`,
+		`clash_download_bytes_per_second{server="fake"} 100`,
+		"# TYPE clash_download_bytes_total counter",
+		`clash_download_bytes_total{server="fake"} 50`,
+		`clash_connections{server="fake"} 1`,
+		`clash_proxy_up{server="fake",proxy="JP 02",type="Vmess"} 1`,
+		`clash_proxy_delay_milliseconds{server="fake",proxy="JP 02",type="Vmess"} 80`,
+		`clash_proxy_up{server="fake",proxy="US 03",type="Trojan"} 0`,
+	} {
+		if !strings.Contains(out, line+"\n") {
+			t.Errorf("missing %q in:\n%s", line, out)
+		}
+	}
+
+	// failed proxies are down, without a delay
+	if strings.Contains(out, `clash_proxy_delay_milliseconds{server="fake",proxy="US 03"`) {
+		t.Errorf("unexpected delay of a failed proxy in:\n%s", out)
+	}
+
+	m := metric{"clash_up", []string{"server", "a \"b\"\\"}, 1}
+	if s := m.String(); s != `clash_up{server="a \"b\"\\"} 1` {
+		t.Fatalf("unexpected escaping %s", s)
+	}
+}
+
+func TestConnectionsList(t *testing.T) {
+	srv := setup(t)
+	srv.Lock()
+	srv.Connections = []api.Connection{
+		{UUID: "1", Metadata: api.ConnectionMetadata{Host: "a.com", DstPort: "443", NetWork: "tcp", Type: "HTTP", SrcIP: "192.168.1.2"}, DownloadTotal: 50},
+		{UUID: "2", Metadata: api.ConnectionMetadata{Host: "b.com", DstPort: "443", NetWork: "udp", Type: "Socks5", SrcIP: "192.168.1.3"}, DownloadTotal: 2048},
+		{UUID: "3", Metadata: api.ConnectionMetadata{Host: "c.com", DstPort: "80", NetWork: "tcp", Type: "HTTP", SrcIP: "10.0.0.1"}, DownloadTotal: 10},
+	}
+	srv.Unlock()
+
+	out, err := capture(t, func() error {
+		return Execute([]string{"connections", "--source", "192.168.1.0/24", "--sort", "download", "--columns", "id,host,download"})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if strings.Contains(out, "c.com") || strings.Index(out, "b.com") > strings.Index(out, "a.com") {
+		t.Fatalf("expected b.com then a.com:\n%s", out)
+	}
+	if !strings.Contains(out, "DOWNLOAD") || strings.Contains(out, "NETWORK") {
+		t.Fatalf("unexpected columns:\n%s", out)
+	}
+	if !strings.Contains(out, "2 of 3 connections") {
+		t.Fatalf("missing footer:\n%s", out)
+	}
+
+	Output = common.FormatJSON
+	out, err = capture(t, func() error {
+		return Execute([]string{"connections", "--type", "http", "--network", "tcp", "--sort", "host", "--reverse"})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var connections []api.Connection
+	if err := json.Unmarshal([]byte(out), &connections); err != nil {
+		t.Fatalf("invalid json %q: %v", out, err)
+	}
+	if len(connections) != 2 || connections[0].UUID != "3" || connections[1].UUID != "1" {
+		t.Fatalf("unexpected connections %+v", connections)
+	}
+
+	for _, args := range [][]string{
+		{"connections", "--columns", "host,bogus"},
+		{"connections", "--sort", "bogus"},
+		{"connections", "--source", "999.1.1.1"},
+	} {
+		if _, err := capture(t, func() error { return Execute(args) }); !isUsageError(err) {
+			t.Fatalf("%v: expected a usage error, got %v", args, err)
+		}
+	}
+}
+
+func TestConnectionsClose(t *testing.T) {
+	srv := setup(t)
+	srv.Lock()
+	srv.Connections = []api.Connection{
+		{UUID: "1", Metadata: api.ConnectionMetadata{Host: "a.com", DstPort: "443"}},
+		{UUID: "2", Metadata: api.ConnectionMetadata{Host: "b.com", DstPort: "443"}},
+		{UUID: "3", Metadata: api.ConnectionMetadata{Host: "c.com", DstPort: "443"}},
+	}
+	srv.Unlock()
+
+	remaining := func() int {
+		srv.Lock()
+		defer srv.Unlock()
+		return len(srv.Connections)
+	}
+
+	defer func(f func() bool) { stdinIsTerminal = f }(stdinIsTerminal)
+	stdinIsTerminal = func() bool { return false }
+
+	// no confirmation can be asked without a terminal
+	_, err := capture(t, func() error { return Execute([]string{"connections", "close", "--host", "a"}) })
+	if !isUsageError(err) || remaining() != 3 {
+		t.Fatalf("expected a usage error and nothing closed, got %v", err)
+	}
+
+	if _, err := capture(t, func() error { return Execute([]string{"connections", "close", "--host", "a", "--yes"}) }); err != nil || remaining() != 2 {
+		t.Fatalf("expected a.com closed, got %v", err)
+	}
+
+	if _, err := capture(t, func() error { return Execute([]string{"connections", "close", "--host", "zzz", "-y"}) }); err == nil {
+		t.Fatal("expected an error when nothing matched")
+	}
+
+	// known ids are closed, unknown ones reported
+	_, err = capture(t, func() error { return Execute([]string{"connections", "close", "2", "9"}) })
+	if err == nil || !strings.Contains(err.Error(), "9: not found") || remaining() != 1 {
+		t.Fatalf("expected 2 closed and 9 reported, got %v", err)
+	}
+}
+
+func TestConnectionWindow(t *testing.T) {
+	conn := func(id, host string, up, down int64) api.Connection {
+		return api.Connection{
+			UUID: id, Metadata: api.ConnectionMetadata{Host: host},
+			UploadTotal: up, DownloadTotal: down, Chain: []string{"HK 01", "Proxy"},
+		}
+	}
+
+	start := time.Now()
+	w := &connectionWindow{window: 2 * time.Second}
+	w.add(start, []api.Connection{conn("1", "a.com", 100, 1000), conn("2", "b.com", 0, 0)})
+	w.add(start.Add(time.Second), []api.Connection{conn("1", "a.com", 200, 3000), conn("2", "b.com", 10, 100), conn("3", "a.com", 0, 500)})
+	// 2 closed, 3 opened after the base
+	w.add(start.Add(2*time.Second), []api.Connection{conn("1", "a.com", 300, 5000), conn("3", "a.com", 0, 1500)})
+
+	groups := w.aggregate(connectionKeys["host"])
+	if len(groups) != 2 || groups[0].Key != "a.com" {
+		t.Fatalf("unexpected groups %+v", groups)
+	}
+	if g := groups[0]; g.Active != 2 || g.Upload != 200 || g.Download != 5500 || g.DownloadRate != 2750 || g.DownloadTotal != 6500 {
+		t.Fatalf("unexpected a.com %+v", g)
+	}
+	if g := groups[1]; g.Active != 0 || g.Connections != 1 || g.Download != 100 {
+		t.Fatalf("unexpected b.com %+v", g)
+	}
+
+	if groups := w.aggregate(connectionKeys["proxy"]); len(groups) != 1 || groups[0].Key != "HK 01" {
+		t.Fatalf("expected a group of the final outbound, got %+v", groups)
+	}
+
+	// the base moves with the window
+	w.add(start.Add(4*time.Second), []api.Connection{conn("1", "a.com", 300, 6000)})
+	if w.span() != 2*time.Second {
+		t.Fatalf("expected a span of 2s, got %s", w.span())
+	}
+}
+
+func TestConnectionsTop(t *testing.T) {
+	srv := setup(t)
+	srv.Lock()
+	srv.Connections = []api.Connection{
+		{UUID: "1", Metadata: api.ConnectionMetadata{Host: "a.com"}, Rule: "DomainSuffix", RulePayload: "a.com", Chain: []string{"HK 01", "Proxy"}, DownloadTotal: 50},
+		{UUID: "2", Metadata: api.ConnectionMetadata{Host: "b.com"}, Rule: "Match", Chain: []string{"DIRECT"}, DownloadTotal: 20},
+	}
+	srv.Unlock()
+	Output = common.FormatJSON
+
+	out, err := capture(t, func() error {
+		return Execute([]string{"connections", "top", "--by", "rule", "--interval", "100ms", "--duration", "250ms"})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var groups []ConnectionGroup
+	if err := json.Unmarshal([]byte(out), &groups); err != nil {
+		t.Fatalf("invalid json %q: %v", out, err)
+	}
+	if len(groups) != 2 || groups[0].Key != "DomainSuffix(a.com)" || groups[0].DownloadTotal != 50 || groups[1].Key != "Match" {
+		t.Fatalf("unexpected groups %+v", groups)
+	}
+
+	_, err = capture(t, func() error { return Execute([]string{"connections", "top", "--by", "port"}) })
+	if !isUsageError(err) {
+		t.Fatalf("expected a usage error, got %v", err)
+	}
+}
+
+func TestConnectionRecorder(t *testing.T) {
+	conn := func(id, host string, down int64) api.Connection {
+		return api.Connection{UUID: id, Metadata: api.ConnectionMetadata{Host: host, DstPort: "443"}, DownloadTotal: down, Rule: "Match", Chain: []string{"HK 01", "Proxy"}}
+	}
+
+	out := &strings.Builder{}
+	r := newConnectionRecorder(out, &connectionFilter{})
+	start := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	steps := [][]api.Connection{
+		{conn("1", "a.com", 10), conn("2", "b.com", 20)},
+		{conn("1", "a.com", 30)},
+		{conn("1", "a.com", 50), conn("3", "c.com", 5)},
+	}
+	for i, connections := range steps {
+		if err := r.add(start.Add(time.Duration(i)*time.Second), connections); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := r.flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("expected 3 records, got %q", out.String())
+	}
+
+	var records []ConnectionRecord
+	for _, line := range lines {
+		record := ConnectionRecord{}
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			t.Fatalf("invalid record %q: %v", line, err)
+		}
+		records = append(records, record)
+	}
+
+	// 2 closed first, then 1 and 3 still open
+	if r := records[0]; r.UUID != "2" || r.Open || !r.LastSeen.Equal(start) || r.DownloadTotal != 20 {
+		t.Fatalf("unexpected closed record %+v", r)
+	}
+	if r := records[1]; r.UUID != "1" || !r.Open || !r.FirstSeen.Equal(start) || !r.LastSeen.Equal(start.Add(2*time.Second)) || r.DownloadTotal != 50 || r.BaseDownload != 10 || r.Chain[0] != "HK 01" {
+		t.Fatalf("unexpected open record %+v", r)
+	}
+	if r := records[2]; r.UUID != "3" || r.BaseDownload != 0 {
+		t.Fatalf("expected no base for a connection opened while recording, got %+v", r)
+	}
+}
+
+func TestConnectionRecorderCheckpoint(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "connections.jsonl")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	r := newConnectionRecorder(f, &connectionFilter{})
+	start := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	conn := func(id string, down int64) api.Connection {
+		return api.Connection{UUID: id, Metadata: api.ConnectionMetadata{Host: "a.com", DstPort: "443"}, DownloadTotal: down}
+	}
+
+	// 2 is checkpointed, then closed without being seen again
+	steps := []func() error{
+		func() error { return r.add(start, []api.Connection{conn("1", 10), conn("2", 20)}) },
+		r.checkpoint,
+		func() error { return r.add(start.Add(time.Second), []api.Connection{conn("1", 30)}) },
+		r.checkpoint,
+		func() error { return r.add(start.Add(2*time.Second), []api.Connection{conn("1", 50)}) },
+		r.flush,
+	}
+	for _, step := range steps {
+		if err := step(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if r.recorded != 2 {
+		t.Fatalf("expected checkpoints left out of the count, got %d", r.recorded)
+	}
+
+	records, err := loadConnectionRecords([]string{path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Slice(records, func(i, j int) bool { return records[i].UUID < records[j].UUID })
+
+	if len(records) != 2 {
+		t.Fatalf("expected 2 connections, got %+v", records)
+	}
+	if r := records[0]; !r.Open || r.DownloadTotal != 50 || !r.LastSeen.Equal(start.Add(2*time.Second)) {
+		t.Fatalf("expected the last record of 1, got %+v", r)
+	}
+	if r := records[1]; r.Open || r.DownloadTotal != 20 {
+		t.Fatalf("expected 2 closed, got %+v", r)
+	}
+}
+
+func TestConnectionsReplay(t *testing.T) {
+	setup(t)
+
+	start := time.Date(2026, 1, 2, 3, 4, 5, 0, time.Local)
+	records := []ConnectionRecord{
+		{api.Connection{UUID: "1", Metadata: api.ConnectionMetadata{Host: "a.com", DstPort: "443"}, DownloadTotal: 300, Chain: []string{"HK 01", "Proxy"}}, start, start.Add(10 * time.Second), 0, 100, false},
+		{api.Connection{UUID: "2", Metadata: api.ConnectionMetadata{Host: "b.com", DstPort: "443"}, DownloadTotal: 100, Chain: []string{"JP 02", "Proxy"}}, start.Add(5 * time.Second), start.Add(20 * time.Second), 0, 0, true},
+		{api.Connection{UUID: "3", Metadata: api.ConnectionMetadata{Host: "c.com", DstPort: "80"}, DownloadTotal: 50, Chain: []string{"HK 01", "Proxy"}}, start.Add(time.Hour), start.Add(time.Hour), 0, 0, false},
+	}
+
+	path := filepath.Join(t.TempDir(), "connections.jsonl")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	enc := json.NewEncoder(f)
+	for _, r := range records {
+		if err := enc.Encode(r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// a later record of the same connection wins
+	newer := records[0]
+	newer.LastSeen, newer.DownloadTotal = start.Add(15*time.Second), 400
+	if err := enc.Encode(newer); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	out, err := capture(t, func() error {
+		return Execute([]string{"connections", "replay", path, "--until", start.Add(time.Minute).Format("2006-01-02 15:04:05"), "--sort", "download"})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(out, "c.com") || strings.Index(out, "a.com") > strings.Index(out, "b.com") || !strings.Contains(out, "2 connections") {
+		t.Fatalf("unexpected replay:\n%s", out)
+	}
+
+	Output = common.FormatJSON
+	out, err = capture(t, func() error { return Execute([]string{"connections", "replay", path, "--by", "proxy"}) })
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var groups []ConnectionGroup
+	if err := json.Unmarshal([]byte(out), &groups); err != nil {
+		t.Fatalf("invalid json %q: %v", out, err)
+	}
+	if len(groups) != 2 || groups[0].Key != "HK 01" || groups[0].Download != 350 || groups[0].DownloadTotal != 450 || groups[1].Active != 1 {
+		t.Fatalf("unexpected groups %+v", groups)
+	}
+
+	if _, err := capture(t, func() error { return Execute([]string{"connections", "replay", path, "--since", "yesterday"}) }); !isUsageError(err) {
+		t.Fatalf("expected a usage error, got %v", err)
+	}
+}
+
+func TestMode(t *testing.T) {
+	srv := setup(t)
+
+	out, err := capture(t, func() error { return Execute([]string{"mode"}) })
+	if err != nil || !strings.Contains(out, "rule") {
+		t.Fatalf("unexpected mode output %q, %v", out, err)
+	}
+
+	if _, err := capture(t, func() error { return Execute([]string{"mode", ModeGlobal}) }); err != nil {
+		t.Fatal(err)
+	}
+	if mode := srv.Config("mode"); mode != ModeGlobal {
+		t.Fatalf("expected mode global, got %v", mode)
+	}
+
+	if err := Execute([]string{"mode", "bogus"}); !isUsageError(err) {
+		t.Fatalf("expected usage error, got %v", err)
+	}
+}
+
+func TestModeUnauthorized(t *testing.T) {
+	srv := setup(t)
+	srv.Lock()
+	srv.Secret = "secret"
+	srv.Unlock()
+
+	err := Execute([]string{"mode"})
+	if !errors.Is(err, api.ErrUnauthorized) {
+		t.Fatalf("expected unauthorized, got %v", err)
+	}
+}
+
+func TestServer(t *testing.T) {
+	setup(t)
+	Output = common.FormatJSON
+
+	out, err := capture(t, func() error { return Execute([]string{"server", "ls"}) })
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var entries []struct {
+		Name     string `json:"name"`
+		Selected bool   `json:"selected"`
+	}
+	if err := json.Unmarshal([]byte(out), &entries); err != nil {
+		t.Fatalf("invalid json %q: %s", out, err)
+	}
+	if len(entries) != 2 || entries[0].Name != "fake" || !entries[0].Selected {
+		t.Fatalf("unexpected servers %+v", entries)
+	}
+
+	if err := Execute([]string{"server", "rm", "fake"}); err == nil {
+		t.Fatal("removed the selected server")
+	}
+
+	if _, err := capture(t, func() error { return Execute([]string{"server", "rm", "other"}) }); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := common.ReadCfg()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := cfg.Servers["other"]; ok {
+		t.Fatal("server other not removed")
+	}
+
+	if err := Execute([]string{"server", "rm", "other"}); !isUsageError(err) {
+		t.Fatalf("expected usage error, got %v", err)
+	}
+}
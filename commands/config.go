@@ -0,0 +1,203 @@
+package commands
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/yz3358/clash-ctl/common"
+
+	"github.com/jedib0t/go-pretty/v6/table"
+	"github.com/jedib0t/go-pretty/v6/text"
+)
+
+// kinds of runtime config values
+const (
+	configInt    = "int"
+	configBool   = "bool"
+	configString = "string"
+	configEnum   = "enum"
+)
+
+// ConfigKey is a runtime config key accepted by PATCH /configs,
+// nested keys are joined by dot (e.g. tun.enable)
+type ConfigKey struct {
+	Name        string
+	Kind        string
+	Values      []string
+	Description string
+}
+
+var ConfigKeys = []ConfigKey{
+	{Name: "port", Kind: configInt, Description: "http proxy port"},
+	{Name: "socks-port", Kind: configInt, Description: "socks5 proxy port"},
+	{Name: "redir-port", Kind: configInt, Description: "redirect proxy port"},
+	{Name: "tproxy-port", Kind: configInt, Description: "tproxy port"},
+	{Name: "mixed-port", Kind: configInt, Description: "http and socks5 proxy port"},
+	{Name: "allow-lan", Kind: configBool, Description: "allow connections from lan"},
+	{Name: "bind-address", Kind: configString, Description: "address to bind when allow-lan"},
+	{Name: "mode", Kind: configEnum, Values: []string{ModeRule, ModeGlobal, ModeDirect}, Description: "proxy mode"},
+	{Name: "log-level", Kind: configEnum, Values: LogLevels, Description: "log level"},
+	{Name: "ipv6", Kind: configBool, Description: "enable ipv6"},
+	{Name: "tun.enable", Kind: configBool, Description: "enable tun (premium)"},
+	{Name: "tun.stack", Kind: configEnum, Values: []string{"system", "gvisor"}, Description: "tun stack (premium)"},
+}
+
+func GetConfigs() (map[string]any, error) {
+	client, err := defaultClient()
+	if err != nil {
+		return nil, err
+	}
+
+	configs, err := client.Configs(context.Background())
+	if err != nil {
+		return nil, err
+	}
+
+	return configs.Raw, nil
+}
+
+func showConfig(a *Args) error {
+	configs, err := GetConfigs()
+	if err != nil {
+		return err
+	}
+
+	flat := map[string]any{}
+	flattenConfig("", configs, flat)
+
+	keys := make([]string, 0, len(flat))
+	for key := range flat {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var rows []table.Row
+	for _, key := range keys {
+		value := flat[key]
+		switch value.(type) {
+		case []any, map[string]any:
+			buf, _ := json.Marshal(value)
+			value = string(buf)
+		}
+		rows = append(rows, table.Row{key, value})
+	}
+
+	return printResult(configs, table.Row{"Key", "Value"}, rows, table.StyleRounded)
+}
+
+// flattenConfig joins nested keys of configs by dot into flat
+func flattenConfig(prefix string, configs map[string]any, flat map[string]any) {
+	for key, value := range configs {
+		if prefix != "" {
+			key = prefix + "." + key
+		}
+
+		if nested, ok := value.(map[string]any); ok && len(nested) > 0 {
+			flattenConfig(key, nested, flat)
+			continue
+		}
+
+		flat[key] = value
+	}
+}
+
+func setConfig(a *Args) error {
+	name, raw := a.Positional[0], a.Positional[1]
+	key := findConfigKey(name)
+	if key == nil {
+		return common.NewUsageError("unsupported config key %s", name)
+	}
+
+	value, err := key.parse(raw)
+	if err != nil {
+		return err
+	}
+
+	// --- nest the value by the dotted key
+	parts := strings.Split(key.Name, ".")
+	patch := map[string]any{parts[len(parts)-1]: value}
+	for i := len(parts) - 2; i >= 0; i-- {
+		patch = map[string]any{parts[i]: patch}
+	}
+
+	client, err := defaultClient()
+	if err != nil {
+		return err
+	}
+
+	if err := client.PatchConfigs(context.Background(), patch); err != nil {
+		return err
+	}
+
+	fmt.Println(text.FgGreen.Sprintf("%s is now %v", key.Name, value))
+	return nil
+}
+
+func findConfigKey(name string) *ConfigKey {
+	for i := range ConfigKeys {
+		if ConfigKeys[i].Name == name {
+			return &ConfigKeys[i]
+		}
+	}
+
+	return nil
+}
+
+// parse validates raw against the kind of key
+func (k ConfigKey) parse(raw string) (any, error) {
+	switch k.Kind {
+	case configInt:
+		port, err := strconv.Atoi(raw)
+		if err != nil || port < 0 || port > 65535 {
+			return nil, common.NewUsageError("%s should be a port between 0 and 65535", k.Name)
+		}
+		return port, nil
+	case configBool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return nil, common.NewUsageError("%s should be true or false", k.Name)
+		}
+		return b, nil
+	case configEnum:
+		for _, v := range k.Values {
+			if v == raw {
+				return raw, nil
+			}
+		}
+		return nil, common.NewUsageError("%s should be one of %s", k.Name, strings.Join(k.Values, ", "))
+	default:
+		return raw, nil
+	}
+}
+
+// ConfigSetResolver completes the keys and values of `config set`
+func ConfigSetResolver(params []string) []common.Node {
+	nodes := []common.Node{}
+
+	switch len(params) {
+	case 1:
+		for _, k := range ConfigKeys {
+			nodes = append(nodes, common.Node{Text: k.Name, Description: fmt.Sprintf("(%s) %s", k.Kind, k.Description)})
+		}
+	case 2:
+		key := findConfigKey(params[0])
+		if key == nil {
+			break
+		}
+
+		values := key.Values
+		if key.Kind == configBool {
+			values = []string{"true", "false"}
+		}
+
+		for _, v := range values {
+			nodes = append(nodes, common.Node{Text: v, Description: "set " + key.Name + " as " + v})
+		}
+	}
+
+	return nodes
+}
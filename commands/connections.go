@@ -0,0 +1,664 @@
+package commands
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/yz3358/clash-ctl/api"
+	"github.com/yz3358/clash-ctl/common"
+	"github.com/yz3358/clash-ctl/utils"
+
+	"github.com/gorilla/websocket"
+	"github.com/jedib0t/go-pretty/v6/progress"
+	"github.com/jedib0t/go-pretty/v6/table"
+	"github.com/jedib0t/go-pretty/v6/text"
+	"github.com/manifoldco/promptui"
+)
+
+func GetConnections(server common.Server) (*api.ConnectionSnapshot, error) {
+	return newClient(server).Connections(context.Background())
+}
+
+// connectionFilter matches connections by their metadata,
+// empty fields match everything
+type connectionFilter struct {
+	host    *regexp.Regexp
+	rule    string
+	chain   string
+	network string
+	typ     string
+	source  *net.IPNet
+}
+
+// connectionFilterFlags are the flags of a connectionFilter
+var connectionFilterFlags = []Flag{
+	{Name: "host", Default: "", Usage: "filter by host regex"},
+	{Name: "rule", Default: "", Usage: "filter by rule or rule payload"},
+	{Name: "chain", Default: "", Usage: "filter by a member of the proxy chain"},
+	{Name: "network", Default: "", Usage: "filter by network (tcp or udp)"},
+	{Name: "type", Default: "", Usage: "filter by inbound type (HTTP, Socks5, TProxy...)"},
+	{Name: "source", Default: "", Usage: "filter by source IP or CIDR"},
+}
+
+// newConnectionFilter compiles the connectionFilterFlags given in a
+func newConnectionFilter(a *Args) (*connectionFilter, error) {
+	f := &connectionFilter{rule: a.String("rule"), chain: a.String("chain"), network: a.String("network"), typ: a.String("type")}
+	if host := a.String("host"); host != "" {
+		re, err := regexp.Compile(host)
+		if err != nil {
+			return nil, common.NewUsageError("invalid host pattern: %s", err.Error())
+		}
+		f.host = re
+	}
+
+	if source := a.String("source"); source != "" {
+		if !strings.Contains(source, "/") {
+			ip := net.ParseIP(source)
+			if ip == nil {
+				return nil, common.NewUsageError("invalid source IP %s", source)
+			}
+			bits := 8 * len(ip)
+			f.source = &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}
+		} else {
+			_, cidr, err := net.ParseCIDR(source)
+			if err != nil {
+				return nil, common.NewUsageError("invalid source CIDR %s", source)
+			}
+			f.source = cidr
+		}
+	}
+
+	return f, nil
+}
+
+func (f connectionFilter) empty() bool {
+	return f.host == nil && f.rule == "" && f.chain == "" && f.network == "" && f.typ == "" && f.source == nil
+}
+
+func (f connectionFilter) match(c api.Connection) bool {
+	if f.host != nil && !f.host.MatchString(c.Address()) {
+		return false
+	}
+
+	if f.rule != "" && !strings.EqualFold(f.rule, c.Rule) && !strings.EqualFold(f.rule, c.RulePayload) {
+		return false
+	}
+
+	if f.network != "" && !strings.EqualFold(f.network, c.Metadata.NetWork) {
+		return false
+	}
+
+	if f.typ != "" && !strings.EqualFold(f.typ, c.Metadata.Type) {
+		return false
+	}
+
+	if f.source != nil {
+		if ip := net.ParseIP(c.Metadata.SrcIP); ip == nil || !f.source.Contains(ip) {
+			return false
+		}
+	}
+
+	if f.chain != "" {
+		found := false
+		for _, name := range c.Chain {
+			if name == f.chain {
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (f connectionFilter) apply(connections []api.Connection) []api.Connection {
+	matched := []api.Connection{}
+	for _, c := range connections {
+		if f.match(c) {
+			matched = append(matched, c)
+		}
+	}
+
+	return matched
+}
+
+// ConnectionRecord is a connection with the times it was first
+// and last seen, as recorded by `connections record`
+type ConnectionRecord struct {
+	api.Connection
+	FirstSeen time.Time `json:"firstSeen"`
+	LastSeen  time.Time `json:"lastSeen"`
+	// bytes already transferred when recording began,
+	// zero for connections opened while recording
+	BaseUpload   int64 `json:"baseUpload,omitempty"`
+	BaseDownload int64 `json:"baseDownload,omitempty"`
+	// Open is set when the connection was still open as recording stopped
+	Open bool `json:"open,omitempty"`
+}
+
+// Duration is how long the connection lasted, until last seen
+func (r ConnectionRecord) Duration() time.Duration {
+	return r.LastSeen.Sub(r.StartTime()).Round(time.Second)
+}
+
+// connectionColumn is a column of `connections`,
+// less sorting the connections by it
+type connectionColumn struct {
+	header string
+	value  func(r ConnectionRecord) any
+	less   func(a, b ConnectionRecord) bool
+}
+
+// columns of `connections` in display order
+var connectionColumnNames = []string{"id", "host", "source", "network", "type", "chain", "rule", "upload", "download", "total", "time", "first", "last"}
+
+const recordTimeLayout = "2006-01-02 15:04:05"
+
+var connectionColumns = map[string]connectionColumn{
+	"id": {
+		"Id", func(r ConnectionRecord) any { return r.UUID },
+		func(a, b ConnectionRecord) bool { return a.UUID < b.UUID },
+	},
+	"host": {
+		"Host", func(r ConnectionRecord) any { return r.Address() },
+		func(a, b ConnectionRecord) bool { return a.Address() < b.Address() },
+	},
+	"source": {
+		"Source", func(r ConnectionRecord) any { return net.JoinHostPort(r.Metadata.SrcIP, r.Metadata.SrcPort) },
+		func(a, b ConnectionRecord) bool { return a.Metadata.SrcIP < b.Metadata.SrcIP },
+	},
+	"network": {
+		"Network", func(r ConnectionRecord) any { return r.Metadata.NetWork },
+		func(a, b ConnectionRecord) bool { return a.Metadata.NetWork < b.Metadata.NetWork },
+	},
+	"type": {
+		"Type", func(r ConnectionRecord) any { return r.Metadata.Type },
+		func(a, b ConnectionRecord) bool { return a.Metadata.Type < b.Metadata.Type },
+	},
+	"chain": {
+		"Chain", func(r ConnectionRecord) any { return strings.Join(r.Chain, " --> ") },
+		func(a, b ConnectionRecord) bool { return strings.Join(a.Chain, ",") < strings.Join(b.Chain, ",") },
+	},
+	"rule": {
+		"Rule", func(r ConnectionRecord) any { return r.Rule },
+		func(a, b ConnectionRecord) bool { return a.Rule < b.Rule },
+	},
+	"upload": {
+		"Upload", func(r ConnectionRecord) any { return progress.FormatBytes(r.UploadTotal) },
+		func(a, b ConnectionRecord) bool { return a.UploadTotal > b.UploadTotal },
+	},
+	"download": {
+		"Download", func(r ConnectionRecord) any { return progress.FormatBytes(r.DownloadTotal) },
+		func(a, b ConnectionRecord) bool { return a.DownloadTotal > b.DownloadTotal },
+	},
+	"total": {
+		"Total", func(r ConnectionRecord) any { return progress.FormatBytes(r.UploadTotal + r.DownloadTotal) },
+		func(a, b ConnectionRecord) bool { return a.UploadTotal+a.DownloadTotal > b.UploadTotal+b.DownloadTotal },
+	},
+	// the longest lasting first
+	"time": {
+		"Time", func(r ConnectionRecord) any { return r.Duration().String() },
+		func(a, b ConnectionRecord) bool { return a.Duration() > b.Duration() },
+	},
+	"first": {
+		"First Seen", func(r ConnectionRecord) any { return r.FirstSeen.Local().Format(recordTimeLayout) },
+		func(a, b ConnectionRecord) bool { return a.FirstSeen.Before(b.FirstSeen) },
+	},
+	"last": {
+		"Last Seen", func(r ConnectionRecord) any { return r.LastSeen.Local().Format(recordTimeLayout) },
+		func(a, b ConnectionRecord) bool { return a.LastSeen.Before(b.LastSeen) },
+	},
+}
+
+// connectionViewFlags are the flags of a connectionView besides
+// connectionFilterFlags, showing columns by default
+func connectionViewFlags(columns string) []Flag {
+	return []Flag{
+		{Name: "sort", Default: "time", Usage: "sort by a column, bytes descending", Resolver: columnResolver},
+		{Name: "reverse", Default: false, Usage: "reverse the sort order"},
+		{Name: "columns", Default: columns, Usage: "comma separated columns to show", Resolver: columnResolver},
+	}
+}
+
+func columnResolver() []common.Node {
+	nodes := []common.Node{}
+	for _, name := range connectionColumnNames {
+		nodes = append(nodes, common.Node{Text: name, Description: connectionColumns[name].header})
+	}
+
+	return nodes
+}
+
+// parseColumns checks the comma separated column names of list
+func parseColumns(list string) ([]string, error) {
+	var columns []string
+	for _, name := range strings.Split(list, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		if _, ok := connectionColumns[name]; !ok {
+			return nil, common.NewUsageError("unknown column %s, should be one of %s", name, strings.Join(connectionColumnNames, ", "))
+		}
+		columns = append(columns, name)
+	}
+
+	if len(columns) == 0 {
+		return nil, common.NewUsageError("no column to show")
+	}
+	return columns, nil
+}
+
+// connectionView filters, sorts and shows connections,
+// live or replayed
+type connectionView struct {
+	filter  *connectionFilter
+	columns []string
+	less    func(a, b ConnectionRecord) bool
+	reverse bool
+}
+
+// newConnectionView parses the connectionViewFlags and connectionFilterFlags of a
+func newConnectionView(a *Args) (*connectionView, error) {
+	filter, err := newConnectionFilter(a)
+	if err != nil {
+		return nil, err
+	}
+
+	columns, err := parseColumns(a.String("columns"))
+	if err != nil {
+		return nil, err
+	}
+
+	sortBy := strings.ToLower(a.String("sort"))
+	column, ok := connectionColumns[sortBy]
+	if !ok {
+		return nil, common.NewUsageError("unknown sort column %s, should be one of %s", sortBy, strings.Join(connectionColumnNames, ", "))
+	}
+
+	return &connectionView{filter, columns, column.less, a.Bool("reverse")}, nil
+}
+
+// apply returns the records matching the filter, sorted
+func (v *connectionView) apply(records []ConnectionRecord) []ConnectionRecord {
+	matched := []ConnectionRecord{}
+	for _, r := range records {
+		if v.filter.match(r.Connection) {
+			matched = append(matched, r)
+		}
+	}
+
+	sort.SliceStable(matched, func(i, j int) bool {
+		if v.reverse {
+			return v.less(matched[j], matched[i])
+		}
+		return v.less(matched[i], matched[j])
+	})
+	return matched
+}
+
+func (v *connectionView) table(records []ConnectionRecord) (table.Row, []table.Row, table.Style) {
+	header := table.Row{}
+	for _, name := range v.columns {
+		header = append(header, connectionColumns[name].header)
+	}
+
+	rows := []table.Row{}
+	for _, r := range records {
+		row := table.Row{}
+		for _, name := range v.columns {
+			row = append(row, connectionColumns[name].value(r))
+		}
+		rows = append(rows, row)
+	}
+
+	return header, rows, table.StyleRounded
+}
+
+// printConnectionsFooter shows how many of total connections
+// are shown, with the bytes transferred
+func printConnectionsFooter(shown, total int, upload, download int64) {
+	count := fmt.Sprintf("%d connections", total)
+	if shown != total {
+		count = fmt.Sprintf("%d of %d connections", shown, total)
+	}
+
+	fmt.Printf("%s  Upload: %s  Download: %s\n", count,
+		text.FgGreen.Sprint(progress.FormatBytes(upload)),
+		text.FgGreen.Sprint(progress.FormatBytes(download)))
+}
+
+// listConnections shows a snapshot of the connections,
+// as `connections [filters] [--sort column] [--columns list]`
+func listConnections(a *Args) error {
+	view, err := newConnectionView(a)
+	if err != nil {
+		return err
+	}
+
+	server, err := defaultServer()
+	if err != nil {
+		return err
+	}
+
+	snapshot, err := GetConnections(*server)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	records := make([]ConnectionRecord, 0, len(snapshot.Connections))
+	for _, c := range snapshot.Connections {
+		records = append(records, ConnectionRecord{Connection: c, FirstSeen: now, LastSeen: now, Open: true})
+	}
+
+	records = view.apply(records)
+	connections := make([]api.Connection, 0, len(records))
+	for _, r := range records {
+		connections = append(connections, r.Connection)
+	}
+
+	header, rows, style := view.table(records)
+	if err := printResult(connections, header, rows, style); err != nil {
+		return err
+	}
+
+	if Output == common.FormatTable {
+		printConnectionsFooter(len(records), len(snapshot.Connections), snapshot.UploadTotal, snapshot.DownloadTotal)
+	}
+	return nil
+}
+
+// ConnectionRate is a connection with its transfer rates (bytes per second)
+// computed from two successive snapshots
+type ConnectionRate struct {
+	api.Connection
+	UploadRate   float64 `json:"uploadRate"`
+	DownloadRate float64 `json:"downloadRate"`
+}
+
+func (c ConnectionRate) Rate() float64 {
+	return c.UploadRate + c.DownloadRate
+}
+
+func (c ConnectionRate) Total() int64 {
+	return c.UploadTotal + c.DownloadTotal
+}
+
+// connectionRates computes the rates of current connections
+// against prev, the connections of the snapshot taken elapsed ago
+func connectionRates(prev map[string]api.Connection, current []api.Connection, elapsed time.Duration) []ConnectionRate {
+	rates := make([]ConnectionRate, 0, len(current))
+	for _, c := range current {
+		r := ConnectionRate{Connection: c}
+		if p, ok := prev[c.UUID]; ok && elapsed > 0 {
+			r.UploadRate = float64(c.UploadTotal-p.UploadTotal) / elapsed.Seconds()
+			r.DownloadRate = float64(c.DownloadTotal-p.DownloadTotal) / elapsed.Seconds()
+		}
+		rates = append(rates, r)
+	}
+
+	return rates
+}
+
+// connection sort keys of `connections watch`
+var connectionSorters = map[string]func(a, b ConnectionRate) bool{
+	"rate":     func(a, b ConnectionRate) bool { return a.Rate() > b.Rate() },
+	"total":    func(a, b ConnectionRate) bool { return a.Total() > b.Total() },
+	"duration": func(a, b ConnectionRate) bool { return a.StartTime().Before(b.StartTime()) },
+	"host":     func(a, b ConnectionRate) bool { return a.Address() < b.Address() },
+}
+
+func sortKeyResolver() []common.Node {
+	nodes := []common.Node{}
+	for key := range connectionSorters {
+		nodes = append(nodes, common.Node{Text: key, Description: "sort by " + key})
+	}
+
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].Text < nodes[j].Text })
+	return nodes
+}
+
+func watchConnections(a *Args) error {
+	interval, sortBy, limit := a.Duration("interval"), a.String("sort"), a.Int("limit")
+
+	less, ok := connectionSorters[sortBy]
+	if !ok {
+		return common.NewUsageError("unknown sort key %s, should be rate, total, duration or host", sortBy)
+	}
+
+	if interval < 100*time.Millisecond {
+		return common.NewUsageError("interval should be at least 100ms")
+	}
+
+	server, err := defaultServer()
+	if err != nil {
+		return err
+	}
+
+	conn, err := common.MakeWebsocket(*server, fmt.Sprintf("/connections?interval=%d", interval.Milliseconds()))
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	done := make(chan struct{})
+	defer close(done)
+	snapshots, errCh := streamConnections(conn, done)
+
+	sigCh := utils.Signal()
+	defer signal.Stop(sigCh)
+
+	prev := map[string]api.Connection{}
+	var last time.Time
+	for {
+		select {
+		case <-sigCh:
+			fmt.Println()
+			return nil
+		case err := <-errCh:
+			return err
+		case snapshot := <-snapshots:
+			now := time.Now()
+			rates := connectionRates(prev, snapshot.Connections, now.Sub(last))
+			sort.SliceStable(rates, func(i, j int) bool { return less(rates[i], rates[j]) })
+
+			prev = make(map[string]api.Connection, len(snapshot.Connections))
+			for _, c := range snapshot.Connections {
+				prev[c.UUID] = c
+			}
+			last = now
+
+			renderConnectionRates(snapshot, rates, sortBy, limit)
+		}
+	}
+}
+
+// streamConnections reads the snapshots pushed on a /connections
+// websocket until done is closed
+func streamConnections(conn *websocket.Conn, done <-chan struct{}) (<-chan api.ConnectionSnapshot, <-chan error) {
+	snapshots := make(chan api.ConnectionSnapshot)
+	errCh := make(chan error, 1)
+
+	go func() {
+		for {
+			snapshot := api.ConnectionSnapshot{}
+			if err := conn.ReadJSON(&snapshot); err != nil {
+				errCh <- err
+				return
+			}
+
+			select {
+			case snapshots <- snapshot:
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	return snapshots, errCh
+}
+
+func renderConnectionRates(snapshot api.ConnectionSnapshot, rates []ConnectionRate, sortBy string, limit int) {
+	var up, down float64
+	for _, r := range rates {
+		up += r.UploadRate
+		down += r.DownloadRate
+	}
+
+	var rows []table.Row
+	for i, r := range rates {
+		if limit > 0 && i >= limit {
+			break
+		}
+
+		rows = append(rows, table.Row{
+			r.Address(),
+			r.Metadata.NetWork,
+			strings.Join(r.Chain, " --> "),
+			r.Rule,
+			formatRate(r.UploadRate),
+			formatRate(r.DownloadRate),
+			progress.FormatBytes(r.Total()),
+			time.Since(r.StartTime()).Round(time.Second).String(),
+		})
+	}
+
+	// move to top left and clear the screen before redrawing
+	fmt.Print("\033[H\033[2J")
+	fmt.Printf(
+		"%d connections, sorted by %s  Upload: %s  Download: %s  Total: %s / %s\n",
+		len(rates), text.FgCyan.Sprint(sortBy),
+		text.FgGreen.Sprint(formatRate(up)), text.FgGreen.Sprint(formatRate(down)),
+		progress.FormatBytes(snapshot.UploadTotal), progress.FormatBytes(snapshot.DownloadTotal),
+	)
+	renderTable(table.Row{"Host", "Network", "Chain", "Rule", "Up", "Down", "Total", "Time"}, rows, table.StyleRounded)
+}
+
+func formatRate(rate float64) string {
+	return progress.FormatBytes(int64(rate)) + "/s"
+}
+
+// stdinIsTerminal reports whether confirmations can be asked
+var stdinIsTerminal = func() bool {
+	info, err := os.Stdin.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+func closeConnections(a *Args) error {
+	all, yes, ids := a.Bool("all"), a.Bool("yes") || a.Bool("y"), a.Positional
+
+	filter, err := newConnectionFilter(a)
+	if err != nil {
+		return err
+	}
+
+	if !all && filter.empty() && len(ids) == 0 {
+		return common.NewUsageError("should be `connections close <id>|--all|[filters]`")
+	} else if (all || len(ids) > 0) && !filter.empty() {
+		return common.NewUsageError("filters can't be used with --all or an id")
+	}
+
+	server, err := defaultServer()
+	if err != nil {
+		return err
+	}
+
+	snapshot, err := GetConnections(*server)
+	if err != nil {
+		return err
+	}
+
+	var targets []api.Connection
+	var failures []string
+	var firstErr error
+	switch {
+	case all:
+		targets = snapshot.Connections
+	case len(ids) > 0:
+		byID := map[string]api.Connection{}
+		for _, c := range snapshot.Connections {
+			byID[c.UUID] = c
+		}
+		for _, id := range ids {
+			if c, ok := byID[id]; ok {
+				targets = append(targets, c)
+			} else {
+				failures = append(failures, fmt.Sprintf("%s: not found", id))
+			}
+		}
+	default:
+		targets = filter.apply(snapshot.Connections)
+	}
+
+	if len(targets) == 0 {
+		if len(failures) > 0 {
+			return fmt.Errorf("no connection matched: %s", strings.Join(failures, "; "))
+		}
+		return errors.New("no connection matched")
+	}
+
+	if len(ids) == 0 && !yes {
+		if !stdinIsTerminal() {
+			return common.NewUsageError("can't confirm closing %d connections without a terminal, pass --yes", len(targets))
+		}
+
+		for _, c := range targets {
+			fmt.Printf("%s %s %s\n", c.UUID, c.Address(), strings.Join(c.Chain, " --> "))
+		}
+
+		confirm := promptui.Prompt{
+			Label:     fmt.Sprintf("close %d connections", len(targets)),
+			IsConfirm: true,
+		}
+		if _, err := confirm.Run(); err != nil {
+			return common.NewUsageError("closing %d connections canceled, pass --yes to skip the confirmation", len(targets))
+		}
+	}
+
+	client := newClient(*server)
+	closed := 0
+	if all {
+		if err := client.CloseAllConnections(context.Background()); err != nil {
+			return err
+		}
+		closed = len(targets)
+	} else {
+		for _, c := range targets {
+			if err := client.CloseConnection(context.Background(), c.UUID); err != nil {
+				if firstErr == nil {
+					firstErr = err
+				}
+				failures = append(failures, fmt.Sprintf("%s: %s", c.UUID, err.Error()))
+				continue
+			}
+			closed++
+		}
+	}
+
+	if Output == common.FormatTable && closed > 0 {
+		fmt.Println(text.FgGreen.Sprintf("closed %d connections %s", closed, markTrue))
+	}
+
+	if len(failures) == 0 {
+		return nil
+	}
+
+	// wrap the first api error, so the exit code follows it
+	if firstErr != nil {
+		return fmt.Errorf("failed to close %d connections: %w (%s)", len(failures), firstErr, strings.Join(failures, "; "))
+	}
+	return fmt.Errorf("failed to close %d connections: %s", len(failures), strings.Join(failures, "; "))
+}
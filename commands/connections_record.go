@@ -0,0 +1,363 @@
+package commands
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"sort"
+	"time"
+
+	"github.com/yz3358/clash-ctl/api"
+	"github.com/yz3358/clash-ctl/common"
+	"github.com/yz3358/clash-ctl/utils"
+
+	"github.com/jedib0t/go-pretty/v6/text"
+)
+
+// backoff between two reconnects of `connections record`
+const (
+	recordRetryMin = time.Second
+	recordRetryMax = 30 * time.Second
+)
+
+// connectionRecorder writes a ConnectionRecord line
+// for every connection once it closes
+type connectionRecorder struct {
+	w        io.Writer
+	filter   *connectionFilter
+	open     map[string]*ConnectionRecord
+	started  bool
+	recorded int
+}
+
+func newConnectionRecorder(w io.Writer, filter *connectionFilter) *connectionRecorder {
+	return &connectionRecorder{w: w, filter: filter, open: map[string]*ConnectionRecord{}}
+}
+
+// add tracks the connections of a snapshot taken at, recording
+// the ones which were open in the previous snapshot but not anymore
+func (r *connectionRecorder) add(at time.Time, connections []api.Connection) error {
+	seen := map[string]bool{}
+	for _, c := range r.filter.apply(connections) {
+		seen[c.UUID] = true
+		if record, ok := r.open[c.UUID]; ok {
+			record.Connection, record.LastSeen = c, at
+			continue
+		}
+
+		record := &ConnectionRecord{Connection: c, FirstSeen: at, LastSeen: at, Open: true}
+		// connections of the first snapshot transferred bytes before
+		if !r.started {
+			record.BaseUpload, record.BaseDownload = c.UploadTotal, c.DownloadTotal
+		}
+		r.open[c.UUID] = record
+	}
+	r.started = true
+
+	closed := []*ConnectionRecord{}
+	for id, record := range r.open {
+		if !seen[id] {
+			record.Open = false
+			closed = append(closed, record)
+			delete(r.open, id)
+		}
+	}
+
+	if err := r.write(closed); err != nil {
+		return err
+	}
+	r.recorded += len(closed)
+	return nil
+}
+
+// checkpoint records the connections still open and keeps tracking them,
+// so they survive the recorder being killed, replay keeping the
+// last seen record of a connection
+func (r *connectionRecorder) checkpoint() error {
+	return r.write(r.openRecords())
+}
+
+// flush records the connections still open
+func (r *connectionRecorder) flush() error {
+	open := r.openRecords()
+	r.open = map[string]*ConnectionRecord{}
+
+	if err := r.write(open); err != nil {
+		return err
+	}
+	r.recorded += len(open)
+	return nil
+}
+
+func (r *connectionRecorder) openRecords() []*ConnectionRecord {
+	open := make([]*ConnectionRecord, 0, len(r.open))
+	for _, record := range r.open {
+		open = append(open, record)
+	}
+	return open
+}
+
+func (r *connectionRecorder) write(records []*ConnectionRecord) error {
+	sort.Slice(records, func(i, j int) bool {
+		if !records[i].FirstSeen.Equal(records[j].FirstSeen) {
+			return records[i].FirstSeen.Before(records[j].FirstSeen)
+		}
+		return records[i].UUID < records[j].UUID
+	})
+
+	for _, record := range records {
+		buf, err := json.Marshal(record)
+		if err != nil {
+			return err
+		}
+
+		// a single write per line, so rotation never splits one
+		if _, err := r.w.Write(append(buf, '\n')); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// recordConnections streams the connections into a JSON lines file
+// until interrupted, reconnecting when the websocket drops,
+// as `connections record <file> [flags]`
+func recordConnections(a *Args) error {
+	interval, every := a.Duration("interval"), a.Duration("checkpoint")
+	if interval < 100*time.Millisecond || every < 0 {
+		return common.NewUsageError("interval should be at least 100ms, checkpoint at least 0")
+	}
+
+	filter, err := newConnectionFilter(a)
+	if err != nil {
+		return err
+	}
+
+	server, err := defaultServer()
+	if err != nil {
+		return err
+	}
+
+	w, err := utils.NewRotateWriter(a.Positional[0], a.Int64("max-size")*1024*1024, a.Int("backups"))
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+
+	path := fmt.Sprintf("/connections?interval=%d", interval.Milliseconds())
+	conn, err := common.MakeWebsocket(*server, path)
+	if err != nil {
+		return err
+	}
+	// conn and done are replaced on reconnects
+	done := make(chan struct{})
+	defer func() {
+		close(done)
+		conn.Close()
+	}()
+	snapshots, errCh := streamConnections(conn, done)
+
+	sigCh := utils.Signal()
+	defer signal.Stop(sigCh)
+
+	var checkpoints <-chan time.Time
+	if every > 0 {
+		ticker := time.NewTicker(every)
+		defer ticker.Stop()
+		checkpoints = ticker.C
+	}
+
+	if Output == common.FormatTable {
+		fmt.Printf("recording connections to %s (Ctrl-C to stop)\n", a.Positional[0])
+	}
+
+	recorder := newConnectionRecorder(w, filter)
+	backoff := recordRetryMin
+	var retry <-chan time.Time
+	for {
+		select {
+		case <-sigCh:
+			fmt.Println()
+			if err := recorder.flush(); err != nil {
+				return err
+			}
+
+			if Output == common.FormatTable {
+				fmt.Println(text.FgGreen.Sprintf("recorded %d connections %s", recorder.recorded, markTrue))
+			}
+			return nil
+		case <-checkpoints:
+			if err := recorder.checkpoint(); err != nil {
+				return err
+			}
+		case err := <-errCh:
+			// the reader is gone, wait for the next snapshot of a new websocket
+			conn.Close()
+			snapshots, errCh = nil, nil
+			if Output == common.FormatTable {
+				fmt.Println(text.FgYellow.Sprintf("connection lost: %s, reconnecting in %s", err.Error(), backoff))
+			}
+			retry = time.After(backoff)
+		case <-retry:
+			c, err := common.MakeWebsocket(*server, path)
+			if err != nil {
+				if backoff *= 2; backoff > recordRetryMax {
+					backoff = recordRetryMax
+				}
+				retry = time.After(backoff)
+				continue
+			}
+
+			conn, done, retry = c, make(chan struct{}), nil
+			snapshots, errCh = streamConnections(conn, done)
+		case snapshot := <-snapshots:
+			backoff = recordRetryMin
+			if err := recorder.add(time.Now(), snapshot.Connections); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// loadConnectionRecords reads the records of files, keeping the last
+// seen one of a connection recorded more than once (by checkpoints),
+// the closed one when seen last at the same time
+func loadConnectionRecords(paths []string) ([]ConnectionRecord, error) {
+	byID := map[string]ConnectionRecord{}
+	for _, path := range paths {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+
+		scanner := bufio.NewScanner(f)
+		scanner.Buffer(make([]byte, 64*1024), 4*1024*1024)
+		for line := 1; scanner.Scan(); line++ {
+			if len(scanner.Bytes()) == 0 {
+				continue
+			}
+
+			record := ConnectionRecord{}
+			if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+				f.Close()
+				return nil, fmt.Errorf("%s:%d: %w", path, line, err)
+			}
+
+			prev, ok := byID[record.UUID]
+			if !ok || record.LastSeen.After(prev.LastSeen) || (record.LastSeen.Equal(prev.LastSeen) && !record.Open) {
+				byID[record.UUID] = record
+			}
+		}
+
+		err = scanner.Err()
+		f.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	records := make([]ConnectionRecord, 0, len(byID))
+	for _, record := range byID {
+		records = append(records, record)
+	}
+	return records, nil
+}
+
+// record time layouts accepted by --since and --until, in local time
+var recordTimeLayouts = []string{time.RFC3339, recordTimeLayout, "2006-01-02 15:04", "2006-01-02"}
+
+func parseRecordTime(name, value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+
+	for _, layout := range recordTimeLayouts {
+		if t, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+			return t, nil
+		}
+	}
+
+	return time.Time{}, common.NewUsageError("invalid %s time %s, should be like %s", name, value, recordTimeLayout)
+}
+
+// replayConnections shows recorded connections with the views
+// of `connections` or `connections top --by`,
+// as `connections replay <file...> [flags]`
+func replayConnections(a *Args) error {
+	view, err := newConnectionView(a)
+	if err != nil {
+		return err
+	}
+
+	by := a.String("by")
+	key, ok := connectionKeys[by]
+	if by != "" && !ok {
+		return common.NewUsageError("unknown key %s, should be host, rule or proxy", by)
+	}
+
+	since, err := parseRecordTime("since", a.String("since"))
+	if err != nil {
+		return err
+	}
+	until, err := parseRecordTime("until", a.String("until"))
+	if err != nil {
+		return err
+	}
+
+	all, err := loadConnectionRecords(a.Positional)
+	if err != nil {
+		return err
+	}
+
+	// connections alive at some point of the range
+	records := []ConnectionRecord{}
+	for _, r := range all {
+		if (since.IsZero() || !r.LastSeen.Before(since)) && (until.IsZero() || !r.FirstSeen.After(until)) {
+			records = append(records, r)
+		}
+	}
+	total := len(records)
+	records = view.apply(records)
+	if len(records) == 0 && Output == common.FormatTable {
+		fmt.Println("no connection matched")
+		return nil
+	}
+
+	var first, last time.Time
+	var upload, download int64
+	for _, r := range records {
+		if first.IsZero() || r.FirstSeen.Before(first) {
+			first = r.FirstSeen
+		}
+		if r.LastSeen.After(last) {
+			last = r.LastSeen
+		}
+		upload += r.UploadTotal
+		download += r.DownloadTotal
+	}
+
+	if by != "" {
+		deltas := make([]connectionDelta, 0, len(records))
+		for _, r := range records {
+			deltas = append(deltas, connectionDelta{r.Connection, r.UploadTotal - r.BaseUpload, r.DownloadTotal - r.BaseDownload, r.Open})
+		}
+
+		title := fmt.Sprintf("traffic by %s from %s to %s", text.FgCyan.Sprint(by),
+			first.Local().Format(recordTimeLayout), last.Local().Format(recordTimeLayout))
+		return printConnectionGroups(groupConnections(deltas, key, last.Sub(first)), title, by, a.Int("limit"))
+	}
+
+	header, rows, style := view.table(records)
+	if err := printResult(records, header, rows, style); err != nil {
+		return err
+	}
+
+	if Output == common.FormatTable {
+		printConnectionsFooter(len(records), total, upload, download)
+	}
+	return nil
+}
@@ -0,0 +1,297 @@
+package commands
+
+import (
+	"fmt"
+	"os/signal"
+	"sort"
+	"time"
+
+	"github.com/yz3358/clash-ctl/api"
+	"github.com/yz3358/clash-ctl/common"
+	"github.com/yz3358/clash-ctl/utils"
+
+	"github.com/jedib0t/go-pretty/v6/progress"
+	"github.com/jedib0t/go-pretty/v6/table"
+	"github.com/jedib0t/go-pretty/v6/text"
+)
+
+// connection keys `connections top` aggregates by
+var connectionKeys = map[string]func(c api.Connection) string{
+	"host": func(c api.Connection) string {
+		if c.Metadata.Host != "" {
+			return c.Metadata.Host
+		}
+		return c.Metadata.DstIP
+	},
+	"rule": func(c api.Connection) string {
+		if c.RulePayload != "" {
+			return fmt.Sprintf("%s(%s)", c.Rule, c.RulePayload)
+		}
+		return c.Rule
+	},
+	// chains start with the final outbound, followed by its groups
+	"proxy": func(c api.Connection) string {
+		if len(c.Chain) == 0 {
+			return ""
+		}
+		return c.Chain[0]
+	},
+}
+
+var connectionKeyHeaders = map[string]string{"host": "Host", "rule": "Rule", "proxy": "Proxy"}
+
+func groupKeyResolver() []common.Node {
+	return []common.Node{
+		{Text: "host", Description: "aggregate by destination host"},
+		{Text: "rule", Description: "aggregate by rule and payload"},
+		{Text: "proxy", Description: "aggregate by final outbound proxy"},
+	}
+}
+
+// timedSnapshot is the connections of a snapshot by id,
+// with the time it was taken
+type timedSnapshot struct {
+	at          time.Time
+	connections map[string]api.Connection
+}
+
+// connectionWindow keeps the snapshots of a sampling window
+type connectionWindow struct {
+	window    time.Duration
+	snapshots []timedSnapshot
+}
+
+func (w *connectionWindow) add(at time.Time, connections []api.Connection) {
+	snapshot := timedSnapshot{at, make(map[string]api.Connection, len(connections))}
+	for _, c := range connections {
+		snapshot.connections[c.UUID] = c
+	}
+	w.snapshots = append(w.snapshots, snapshot)
+
+	// the oldest snapshot kept is the base, at or before the start of the window
+	for len(w.snapshots) > 2 && at.Sub(w.snapshots[1].at) >= w.window {
+		w.snapshots = w.snapshots[1:]
+	}
+}
+
+// span is the time covered by the window
+func (w *connectionWindow) span() time.Duration {
+	if len(w.snapshots) < 2 {
+		return 0
+	}
+
+	return w.snapshots[len(w.snapshots)-1].at.Sub(w.snapshots[0].at)
+}
+
+// ConnectionGroup is the traffic of the connections sharing a key,
+// Upload and Download being transferred within the window
+type ConnectionGroup struct {
+	Key           string  `json:"key"`
+	Active        int     `json:"active"`
+	Connections   int     `json:"connections"`
+	Upload        int64   `json:"upload"`
+	Download      int64   `json:"download"`
+	UploadRate    float64 `json:"uploadRate"`
+	DownloadRate  float64 `json:"downloadRate"`
+	UploadTotal   int64   `json:"uploadTotal"`
+	DownloadTotal int64   `json:"downloadTotal"`
+}
+
+// connectionDelta is a connection with the bytes it transferred
+// within a window, active if still open at its end
+type connectionDelta struct {
+	api.Connection
+	upload   int64
+	download int64
+	active   bool
+}
+
+// deltas returns every connection seen in the window. The bytes of a
+// connection are counted from the base snapshot, or from zero when it
+// opened later, to the last snapshot it was seen in, so connections
+// closed within the window are counted too
+func (w *connectionWindow) deltas() []connectionDelta {
+	if len(w.snapshots) == 0 {
+		return []connectionDelta{}
+	}
+
+	base, latest := w.snapshots[0], w.snapshots[len(w.snapshots)-1]
+	last := map[string]api.Connection{}
+	for _, s := range w.snapshots {
+		for id, c := range s.connections {
+			last[id] = c
+		}
+	}
+
+	deltas := make([]connectionDelta, 0, len(last))
+	for id, c := range last {
+		d := connectionDelta{Connection: c, upload: c.UploadTotal, download: c.DownloadTotal}
+		if b, ok := base.connections[id]; ok {
+			d.upload, d.download = d.upload-b.UploadTotal, d.download-b.DownloadTotal
+		} else if len(w.snapshots) == 1 {
+			// no base yet to tell what was transferred within the window
+			d.upload, d.download = 0, 0
+		}
+		_, d.active = latest.connections[id]
+		deltas = append(deltas, d)
+	}
+
+	return deltas
+}
+
+// aggregate groups the connections of the window by key
+func (w *connectionWindow) aggregate(key func(c api.Connection) string) []ConnectionGroup {
+	return groupConnections(w.deltas(), key, w.span())
+}
+
+// groupConnections sums deltas by key, the rates being over span
+func groupConnections(deltas []connectionDelta, key func(c api.Connection) string, span time.Duration) []ConnectionGroup {
+	groups := map[string]*ConnectionGroup{}
+	for _, d := range deltas {
+		k := key(d.Connection)
+		g, ok := groups[k]
+		if !ok {
+			g = &ConnectionGroup{Key: k}
+			groups[k] = g
+		}
+
+		g.Connections++
+		if d.active {
+			g.Active++
+		}
+		g.UploadTotal += d.UploadTotal
+		g.DownloadTotal += d.DownloadTotal
+		g.Upload += d.upload
+		g.Download += d.download
+	}
+
+	result := make([]ConnectionGroup, 0, len(groups))
+	for _, g := range groups {
+		if span > 0 {
+			g.UploadRate = float64(g.Upload) / span.Seconds()
+			g.DownloadRate = float64(g.Download) / span.Seconds()
+		}
+		result = append(result, *g)
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		if a, b := result[i].Upload+result[i].Download, result[j].Upload+result[j].Download; a != b {
+			return a > b
+		}
+		if a, b := result[i].UploadTotal+result[i].DownloadTotal, result[j].UploadTotal+result[j].DownloadTotal; a != b {
+			return a > b
+		}
+		return result[i].Key < result[j].Key
+	})
+	return result
+}
+
+// topConnections aggregates the traffic of connections by key over a
+// sampling window, redrawn live until interrupted, or printed once
+// after --duration, as `connections top [flags]`
+func topConnections(a *Args) error {
+	by, window, interval, duration, limit := a.String("by"), a.Duration("window"), a.Duration("interval"), a.Duration("duration"), a.Int("limit")
+
+	key, ok := connectionKeys[by]
+	if !ok {
+		return common.NewUsageError("unknown key %s, should be host, rule or proxy", by)
+	}
+
+	if interval < 100*time.Millisecond || window < interval || duration < 0 {
+		return common.NewUsageError("interval should be at least 100ms, window at least the interval")
+	}
+
+	filter, err := newConnectionFilter(a)
+	if err != nil {
+		return err
+	}
+
+	server, err := defaultServer()
+	if err != nil {
+		return err
+	}
+
+	conn, err := common.MakeWebsocket(*server, fmt.Sprintf("/connections?interval=%d", interval.Milliseconds()))
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	done := make(chan struct{})
+	defer close(done)
+	snapshots, errCh := streamConnections(conn, done)
+
+	sigCh := utils.Signal()
+	defer signal.Stop(sigCh)
+
+	var timeout <-chan time.Time
+	if duration > 0 {
+		timer := time.NewTimer(duration)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	w := &connectionWindow{window: window}
+	live := Output == common.FormatTable && duration == 0
+	for {
+		select {
+		case <-sigCh:
+			fmt.Println()
+			if live {
+				return nil
+			}
+			return printConnectionGroups(w.aggregate(key), connectionGroupsTitle(w, by), by, limit)
+		case <-timeout:
+			return printConnectionGroups(w.aggregate(key), connectionGroupsTitle(w, by), by, limit)
+		case err := <-errCh:
+			return err
+		case snapshot := <-snapshots:
+			w.add(time.Now(), filter.apply(snapshot.Connections))
+			if live {
+				// move to top left and clear the screen before redrawing
+				fmt.Print("\033[H\033[2J")
+				fmt.Println(connectionGroupsTitle(w, by))
+				renderTable(connectionGroupsTable(w.aggregate(key), by, limit))
+			}
+		}
+	}
+}
+
+func connectionGroupsTitle(w *connectionWindow, by string) string {
+	return fmt.Sprintf("traffic by %s over the last %s", text.FgCyan.Sprint(by), w.span().Round(time.Second))
+}
+
+func connectionGroupsTable(groups []ConnectionGroup, by string, limit int) (table.Row, []table.Row, table.Style) {
+	rows := []table.Row{}
+	for i, g := range groups {
+		if limit > 0 && i >= limit {
+			break
+		}
+
+		rows = append(rows, table.Row{
+			g.Key,
+			fmt.Sprintf("%d/%d", g.Active, g.Connections),
+			formatRate(g.UploadRate),
+			formatRate(g.DownloadRate),
+			progress.FormatBytes(g.Upload + g.Download),
+			progress.FormatBytes(g.UploadTotal + g.DownloadTotal),
+		})
+	}
+
+	header := table.Row{connectionKeyHeaders[by], "Active", "Up", "Down", "Window", "Total"}
+	return header, rows, table.StyleRounded
+}
+
+// printConnectionGroups prints groups once, below title in table output
+func printConnectionGroups(groups []ConnectionGroup, title, by string, limit int) error {
+	if limit > 0 && len(groups) > limit {
+		groups = groups[:limit]
+	}
+
+	if Output == common.FormatTable {
+		fmt.Println(title)
+	}
+
+	header, rows, style := connectionGroupsTable(groups, by, limit)
+	return printResult(groups, header, rows, style)
+}
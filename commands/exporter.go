@@ -0,0 +1,357 @@
+package commands
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/yz3358/clash-ctl/common"
+	"github.com/yz3358/clash-ctl/utils"
+
+	"github.com/gorilla/websocket"
+)
+
+var exporterFlags = []Flag{
+	{Name: "listen", Default: ":9101", Usage: "address to serve /metrics on"},
+	{Name: "timeout", Default: 5 * time.Second, Usage: "max time to scrape a server"},
+}
+
+// trafficStale is how long the last /traffic sample of a server is exported,
+// the core pushes one every second
+const trafficStale = 5 * time.Second
+
+// metricFamily is a metric name with its help and type
+type metricFamily struct {
+	name string
+	help string
+	kind string
+}
+
+// metric families in the order they are exported
+var metricFamilies = []metricFamily{
+	{"clash_up", "Whether the controller answered /version.", "gauge"},
+	{"clash_info", "Version of the core, always 1.", "gauge"},
+	{"clash_mode", "Proxy mode of the core, 1 for the current one.", "gauge"},
+	{"clash_upload_bytes_per_second", "Upload rate from /traffic.", "gauge"},
+	{"clash_download_bytes_per_second", "Download rate from /traffic.", "gauge"},
+	{"clash_upload_bytes_total", "Bytes uploaded since the core started.", "counter"},
+	{"clash_download_bytes_total", "Bytes downloaded since the core started.", "counter"},
+	{"clash_connections", "Active connections.", "gauge"},
+	{"clash_proxy_up", "Whether the latest delay test of a proxy passed.", "gauge"},
+	{"clash_proxy_delay_milliseconds", "Latest delay test of a proxy, left out when it failed.", "gauge"},
+}
+
+// metric is a sample of the Prometheus text format,
+// labels being name, value pairs
+type metric struct {
+	name   string
+	labels []string
+	value  float64
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func (m metric) String() string {
+	b := strings.Builder{}
+	b.WriteString(m.name)
+	if len(m.labels) > 0 {
+		b.WriteByte('{')
+		for i := 0; i+1 < len(m.labels); i += 2 {
+			if i > 0 {
+				b.WriteByte(',')
+			}
+			fmt.Fprintf(&b, `%s="%s"`, m.labels[i], labelEscaper.Replace(m.labels[i+1]))
+		}
+		b.WriteByte('}')
+	}
+	b.WriteByte(' ')
+	b.WriteString(strconv.FormatFloat(m.value, 'g', -1, 64))
+	return b.String()
+}
+
+// writeMetrics writes metrics in the text format, grouped by family
+func writeMetrics(w io.Writer, metrics []metric) error {
+	for _, f := range metricFamilies {
+		header := false
+		for _, m := range metrics {
+			if m.name != f.name {
+				continue
+			}
+
+			if !header {
+				if _, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", f.name, f.help, f.name, f.kind); err != nil {
+					return err
+				}
+				header = true
+			}
+			if _, err := fmt.Fprintln(w, m); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// trafficWatcher keeps the last /traffic sample of a server,
+// reconnecting when the websocket drops
+type trafficWatcher struct {
+	server common.Server
+
+	mu     sync.Mutex
+	conn   *websocket.Conn
+	sample TrafficSample
+	at     time.Time
+	done   chan struct{}
+}
+
+func newTrafficWatcher(server common.Server) *trafficWatcher {
+	return &trafficWatcher{server: server, done: make(chan struct{})}
+}
+
+func (t *trafficWatcher) run() {
+	for {
+		conn, err := common.MakeWebsocket(t.server, "/traffic")
+		if err == nil {
+			t.mu.Lock()
+			select {
+			case <-t.done:
+				// stopped while dialing
+				t.mu.Unlock()
+				conn.Close()
+				return
+			default:
+				t.conn = conn
+			}
+			t.mu.Unlock()
+
+			for {
+				sample := TrafficSample{}
+				if err := conn.ReadJSON(&sample); err != nil {
+					break
+				}
+
+				t.mu.Lock()
+				t.sample, t.at = sample, time.Now()
+				t.mu.Unlock()
+			}
+			conn.Close()
+		}
+
+		select {
+		case <-t.done:
+			return
+		case <-time.After(trafficStale):
+		}
+	}
+}
+
+func (t *trafficWatcher) stop() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	close(t.done)
+	if t.conn != nil {
+		t.conn.Close()
+	}
+}
+
+// latest returns the last sample, false if it is stale
+func (t *trafficWatcher) latest() (TrafficSample, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.sample, time.Since(t.at) < trafficStale
+}
+
+// exporter serves the metrics of servers, scraped on every request
+type exporter struct {
+	servers map[string]common.Server
+	timeout time.Duration
+	traffic map[string]*trafficWatcher
+}
+
+func newExporter(servers map[string]common.Server, timeout time.Duration) *exporter {
+	e := &exporter{servers: servers, timeout: timeout, traffic: map[string]*trafficWatcher{}}
+	for name, server := range servers {
+		e.traffic[name] = newTrafficWatcher(server)
+	}
+
+	return e
+}
+
+func (e *exporter) start() {
+	for _, t := range e.traffic {
+		go t.run()
+	}
+}
+
+func (e *exporter) stop() {
+	for _, t := range e.traffic {
+		t.stop()
+	}
+}
+
+// scrape collects the metrics of a server, a failing
+// endpoint only leaves out its own metrics
+func (e *exporter) scrape(ctx context.Context, name string) []metric {
+	server := e.servers[name]
+	client := newClient(server)
+	labels := []string{"server", name}
+
+	version, err := client.Version(ctx)
+	if err != nil {
+		return []metric{{"clash_up", labels, 0}}
+	}
+
+	metrics := []metric{
+		{"clash_up", labels, 1},
+		{"clash_info", []string{"server", name, "version", version.Version}, 1},
+	}
+
+	if configs, err := client.Configs(ctx); err == nil {
+		for _, mode := range []string{ModeRule, ModeGlobal, ModeDirect} {
+			value := 0.0
+			if strings.EqualFold(configs.Mode, mode) {
+				value = 1
+			}
+			metrics = append(metrics, metric{"clash_mode", []string{"server", name, "mode", mode}, value})
+		}
+	}
+
+	if sample, ok := e.traffic[name].latest(); ok {
+		metrics = append(metrics,
+			metric{"clash_upload_bytes_per_second", labels, float64(sample.Up)},
+			metric{"clash_download_bytes_per_second", labels, float64(sample.Down)},
+		)
+	}
+
+	if snapshot, err := client.Connections(ctx); err == nil {
+		metrics = append(metrics,
+			metric{"clash_upload_bytes_total", labels, float64(snapshot.UploadTotal)},
+			metric{"clash_download_bytes_total", labels, float64(snapshot.DownloadTotal)},
+			metric{"clash_connections", labels, float64(len(snapshot.Connections))},
+		)
+	}
+
+	if proxies, err := client.Proxies(ctx); err == nil {
+		names := make([]string, 0, len(proxies))
+		for n := range proxies {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+
+		for _, n := range names {
+			p := proxies[n]
+			if len(p.History) == 0 {
+				continue
+			}
+
+			labels := []string{"server", name, "proxy", p.Name, "type", p.Type}
+			delay := p.LastestDelay()
+			if delay <= 0 {
+				metrics = append(metrics, metric{"clash_proxy_up", labels, 0})
+				continue
+			}
+
+			metrics = append(metrics,
+				metric{"clash_proxy_up", labels, 1},
+				metric{"clash_proxy_delay_milliseconds", labels, float64(delay)},
+			)
+		}
+	}
+
+	return metrics
+}
+
+// ServeHTTP scrapes every server concurrently
+func (e *exporter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	names := make([]string, 0, len(e.servers))
+	for name := range e.servers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	ctx, cancel := context.WithTimeout(r.Context(), e.timeout)
+	defer cancel()
+
+	results := make([][]metric, len(names))
+	wg := sync.WaitGroup{}
+	for i, name := range names {
+		wg.Add(1)
+		go func(i int, name string) {
+			defer wg.Done()
+			results[i] = e.scrape(ctx, name)
+		}(i, name)
+	}
+	wg.Wait()
+
+	metrics := []metric{}
+	for _, r := range results {
+		metrics = append(metrics, r...)
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	if err := writeMetrics(w, metrics); err != nil {
+		fmt.Fprintf(os.Stderr, "%s can't write metrics to %s: %s\n", time.Now().Format("15:04:05"), r.RemoteAddr, err.Error())
+	}
+}
+
+// exportMetrics serves the metrics of all configured servers
+// until interrupted, as `exporter [flags]`
+func exportMetrics(a *Args) error {
+	cfg, err := common.ReadCfg()
+	if err != nil {
+		return err
+	}
+
+	if len(cfg.Servers) == 0 {
+		return common.NewUsageError("no server configured, add one with `server add`")
+	}
+
+	ln, err := net.Listen("tcp", a.String("listen"))
+	if err != nil {
+		return err
+	}
+
+	e := newExporter(cfg.Servers, a.Duration("timeout"))
+	e.start()
+	defer e.stop()
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", e)
+	srv := &http.Server{Handler: mux}
+
+	errCh := make(chan error, 1)
+	go func() { errCh <- srv.Serve(ln) }()
+
+	if Output == common.FormatTable {
+		fmt.Printf("serving metrics of %d servers on http://%s/metrics\n", len(cfg.Servers), ln.Addr())
+	}
+
+	sigCh := utils.Signal()
+	defer signal.Stop(sigCh)
+
+	select {
+	case <-sigCh:
+		fmt.Println()
+	case err := <-errCh:
+		if !errors.Is(err, http.ErrServerClosed) {
+			return err
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	return srv.Shutdown(ctx)
+}
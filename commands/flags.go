@@ -0,0 +1,53 @@
+package commands
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"strings"
+
+	"github.com/yz3358/clash-ctl/common"
+)
+
+// newFlagSet returns a flag set for the arguments of a sub command,
+// parse it with parseFlags to get usage errors instead of exiting.
+func newFlagSet(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(&bytes.Buffer{})
+	return fs
+}
+
+// parseFlags parses args into fs, allowing flags
+// after positional arguments unless separated by "--"
+func parseFlags(fs *flag.FlagSet, args []string) error {
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return flagsError(fs, err)
+		}
+
+		consumed := len(args) - fs.NArg()
+		if fs.NArg() == 0 || (consumed > 0 && args[consumed-1] == "--") {
+			positional = append(positional, fs.Args()...)
+			break
+		}
+
+		positional = append(positional, fs.Arg(0))
+		args = fs.Args()[1:]
+	}
+
+	// leave the positional arguments in fs.Args()
+	return fs.Parse(append([]string{"--"}, positional...))
+}
+
+func flagsError(fs *flag.FlagSet, err error) error {
+	usage := &strings.Builder{}
+	fs.SetOutput(usage)
+	fs.PrintDefaults()
+
+	if errors.Is(err, flag.ErrHelp) {
+		return common.NewUsageError("usage of %s:\n%s", fs.Name(), usage.String())
+	}
+
+	return common.NewUsageError("%s: %s\n%s", fs.Name(), err.Error(), usage.String())
+}
@@ -0,0 +1,142 @@
+package commands
+
+import (
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/yz3358/clash-ctl/common"
+
+	"github.com/jedib0t/go-pretty/v6/table"
+)
+
+// best scores of fuzzyScore by kind of match,
+// each kind scores above the best of the next one
+const (
+	scoreExact       = 1000
+	scorePrefix      = 800
+	scoreSubstring   = 600
+	scoreSubsequence = 400
+)
+
+// fuzzyScore scores how well pattern matches name, case-insensitive:
+// exact > prefix > substring > subsequence, 0 for no match.
+// Shorter names and earlier, tighter matches score higher in each kind.
+func fuzzyScore(pattern, name string) int {
+	p, n := []rune(strings.ToLower(pattern)), []rune(strings.ToLower(name))
+	if len(p) == 0 {
+		return 0
+	}
+
+	extra := len(n) - len(p)
+	if i := strings.Index(string(n), string(p)); i >= 0 {
+		// in runes like extra, e.g. a flag emoji is 2 runes but 8 bytes
+		i = utf8.RuneCountInString(string(n)[:i])
+		switch {
+		case extra == 0:
+			return scoreExact
+		case i == 0:
+			return scorePrefix - clamp(extra, 0, scorePrefix-scoreSubstring-1)
+		default:
+			return scoreSubstring - clamp(i+extra, 0, scoreSubstring-scoreSubsequence-1)
+		}
+	}
+
+	// --- subsequence, rewarding runs of consecutive characters
+	first, last, consecutive := -1, -1, 0
+	j := 0
+	for i, r := range n {
+		if j == len(p) {
+			break
+		}
+
+		if r == p[j] {
+			if first < 0 {
+				first = i
+			} else if last == i-1 {
+				consecutive++
+			}
+			last = i
+			j++
+		}
+	}
+
+	if j < len(p) {
+		return 0
+	}
+
+	gaps := (last - first + 1) - len(p)
+	return clamp(scoreSubsequence/2+10*consecutive-gaps-first, 1, scoreSubsequence)
+}
+
+func clamp(v, lo, hi int) int {
+	if v < lo {
+		return lo
+	} else if v > hi {
+		return hi
+	}
+	return v
+}
+
+// fuzzyMatch is a proxy of a SelectorTable matched by a name fragment
+type fuzzyMatch struct {
+	ID    int
+	Proxy Proxy
+	Score int
+}
+
+// fuzzyFind returns the proxies of s matching pattern, best first.
+// Subsequence matches are only kept when no name contains pattern.
+func (s SelectorTable) fuzzyFind(pattern string) []fuzzyMatch {
+	matches := []fuzzyMatch{}
+	substring := false
+	for id, proxy := range s.Proxies {
+		if score := fuzzyScore(pattern, proxy.Name); score > 0 {
+			matches = append(matches, fuzzyMatch{id, proxy, score})
+			substring = substring || score > scoreSubsequence
+		}
+	}
+
+	sort.SliceStable(matches, func(i, j int) bool { return matches[i].Score > matches[j].Score })
+
+	if substring {
+		for i, m := range matches {
+			if m.Score <= scoreSubsequence {
+				return matches[:i]
+			}
+		}
+	}
+
+	return matches
+}
+
+// UseByName switches to the proxy matching pattern if it is the only match
+// (or the only exact one), otherwise shows the ranked candidates
+func (s SelectorTable) UseByName(pattern string) error {
+	matches := s.fuzzyFind(pattern)
+	switch {
+	case len(matches) == 0:
+		return common.NewUsageError("no proxy matches %s in group %s", pattern, s.Selector.Name)
+	case len(matches) == 1, matches[0].Score == scoreExact && matches[1].Score < scoreExact:
+		return s.Use(matches[0].ID)
+	}
+
+	type entry struct {
+		ID    int    `json:"id"`
+		Name  string `json:"name"`
+		Delay int    `json:"delay"`
+	}
+
+	entries := []entry{}
+	rows := []table.Row{}
+	for _, m := range matches {
+		entries = append(entries, entry{m.ID, m.Proxy.Name, m.Proxy.LastestDelay()})
+		rows = append(rows, table.Row{m.ID, m.Proxy.Name, formatDelay(m.Proxy.LastestDelay())})
+	}
+
+	if err := printResult(entries, table.Row{"Id", "Proxy Name", "Delay"}, rows, table.StyleRounded); err != nil {
+		return err
+	}
+
+	return common.NewUsageError("%d proxies match %s, use one of the ids above", len(matches), pattern)
+}
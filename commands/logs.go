@@ -0,0 +1,154 @@
+package commands
+
+import (
+	"fmt"
+	"io"
+	"os/signal"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/yz3358/clash-ctl/common"
+	"github.com/yz3358/clash-ctl/utils"
+
+	"github.com/jedib0t/go-pretty/v6/text"
+)
+
+// log levels of the clash core, from verbose to quiet
+var LogLevels = []string{"debug", "info", "warning", "error", "silent"}
+
+var logColors = map[string]text.Color{
+	"debug":   text.FgHiBlack,
+	"info":    text.FgCyan,
+	"warning": text.FgYellow,
+	"error":   text.FgRed,
+}
+
+type LogEntry struct {
+	Type    string `json:"type"`
+	Payload string `json:"payload"`
+}
+
+// streamLogs streams the core logs until interrupted,
+// as `logs [level] [flags]`
+func streamLogs(a *Args) error {
+	level := "info"
+	if len(a.Positional) > 0 {
+		level = a.Positional[0]
+	}
+
+	if !isLogLevel(level) {
+		return common.NewUsageError("unknown log level %s, should be one of %s", level, strings.Join(LogLevels, ", "))
+	}
+
+	include, exclude, save := a.String("include"), a.String("exclude"), a.String("save")
+
+	var includeRe, excludeRe *regexp.Regexp
+	var err error
+	if include != "" {
+		if includeRe, err = regexp.Compile(include); err != nil {
+			return common.NewUsageError("invalid include pattern: %s", err.Error())
+		}
+	}
+	if exclude != "" {
+		if excludeRe, err = regexp.Compile(exclude); err != nil {
+			return common.NewUsageError("invalid exclude pattern: %s", err.Error())
+		}
+	}
+
+	var file io.Writer
+	if save != "" {
+		w, err := utils.NewRotateWriter(save, a.Int64("max-size")*1024*1024, a.Int("backups"))
+		if err != nil {
+			return err
+		}
+		defer w.Close()
+		file = w
+	}
+
+	server, err := defaultServer()
+	if err != nil {
+		return err
+	}
+
+	conn, err := common.MakeWebsocket(*server, "/logs?level="+level)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	entries := make(chan LogEntry)
+	errCh := make(chan error, 1)
+	done := make(chan struct{})
+	defer close(done)
+
+	go func() {
+		for {
+			entry := LogEntry{}
+			if err := conn.ReadJSON(&entry); err != nil {
+				errCh <- err
+				return
+			}
+
+			select {
+			case entries <- entry:
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	sigCh := utils.Signal()
+	defer signal.Stop(sigCh)
+
+	for {
+		select {
+		case <-sigCh:
+			fmt.Println()
+			return nil
+		case err := <-errCh:
+			return err
+		case entry := <-entries:
+			if includeRe != nil && !includeRe.MatchString(entry.Payload) {
+				continue
+			}
+			if excludeRe != nil && excludeRe.MatchString(entry.Payload) {
+				continue
+			}
+
+			now := time.Now().Format("15:04:05")
+			levelText := strings.ToUpper(entry.Type)
+			fmt.Printf("%s %s %s\n", now, logColors[entry.Type].Sprintf("%-7s", levelText), entry.Payload)
+
+			if file != nil {
+				line := fmt.Sprintf("%s %-7s %s\n", time.Now().Format(time.RFC3339), levelText, entry.Payload)
+				if _, err := io.WriteString(file, line); err != nil {
+					return err
+				}
+			}
+		}
+	}
+}
+
+// LogLevelResolver completes the level of `logs`
+func LogLevelResolver(params []string) []common.Node {
+	nodes := []common.Node{}
+	if len(params) > 1 {
+		return nodes
+	}
+
+	for _, level := range LogLevels {
+		nodes = append(nodes, common.Node{Text: level, Description: "show logs of " + level + " and above"})
+	}
+	return nodes
+}
+
+func isLogLevel(level string) bool {
+	for _, l := range LogLevels {
+		if l == level {
+			return true
+		}
+	}
+
+	return false
+}
@@ -1,86 +1,124 @@
 package commands
 
 import (
+	"context"
 	"fmt"
+	"sort"
 	"sync"
 	"time"
 
 	"github.com/yz3358/clash-ctl/common"
 
 	"github.com/jedib0t/go-pretty/v6/progress"
+	"github.com/jedib0t/go-pretty/v6/table"
 	"github.com/jedib0t/go-pretty/v6/text"
 )
 
-func HandleMiscCommand(args []string) {
-	if len(args) == 0 {
-		return
+func showServer(a *Args) error {
+	cfg, err := common.ReadCfg()
+	if err != nil {
+		return err
 	}
 
+	current, server, err := common.GetCurrentServer(cfg)
+	if err != nil {
+		return err
+	}
+
+	serverURL := server.URL()
+	fmt.Printf("now selected %s - %s\n", current, serverURL.String())
+	return nil
+}
+
+func useServer(a *Args) error {
 	cfg, err := common.ReadCfg()
 	if err != nil {
-		return
+		return err
+	}
+
+	name := a.Positional[0]
+	if _, ok := cfg.Servers[name]; !ok {
+		return common.NewUsageError("server %s not found", name)
+	}
+
+	cfg.Selected = name
+	if err := common.SaveCfg(cfg); err != nil {
+		return err
+	}
+	resetSelector()
+
+	fmt.Printf("now use %s\n", text.FgGreen.Sprint(name))
+	return nil
+}
+
+func setOutput(a *Args) error {
+	if len(a.Positional) == 0 {
+		fmt.Println("current output format:", text.FgGreen.Sprint(Output))
+		return nil
+	}
+
+	format, err := common.ParseFormat(a.Positional[0])
+	if err != nil {
+		return err
+	}
+
+	Output = format
+	fmt.Printf("output format is now %s\n", text.FgGreen.Sprint(format))
+	return nil
+}
+
+// OutputResolver completes the formats of `output`
+func OutputResolver(params []string) []common.Node {
+	nodes := []common.Node{}
+	if len(params) > 1 {
+		return nodes
+	}
+
+	for _, f := range common.Formats {
+		nodes = append(nodes, common.Node{Text: string(f), Description: "set output format as " + string(f)})
+	}
+	return nodes
+}
+
+func ping(a *Args) error {
+	cfg, err := common.ReadCfg()
+	if err != nil {
+		return err
+	}
+
+	servers := cfg.Servers
+	if Output != common.FormatTable {
+		return printPing(servers)
 	}
 
-	switch args[0] {
-	case "now":
-		current, server, err := common.GetCurrentServer(cfg)
-		if err != nil {
-			fmt.Println(err.Error())
-			return
-		}
-
-		serverURL := server.URL()
-		fmt.Printf("now selected %s - %s\n", current, serverURL.String())
-	case "use":
-		if len(args) < 2 {
-			fmt.Println("should input server name")
-			return
-		}
-
-		name := args[1]
-		if _, ok := cfg.Servers[name]; !ok {
-			fmt.Printf("serber %s not found\n", name)
-			return
-		}
-
-		cfg.Selected = name
-		if err := common.SaveCfg(cfg); err != nil {
-			fmt.Println(err.Error())
-			return
-		}
-
-		fmt.Printf("now use %s\n", text.FgGreen.Sprint(name))
-	case "ping":
-		servers := cfg.Servers
-
-		pw := progress.NewWriter()
-		pw.SetAutoStop(true)
-		pw.SetMessageWidth(20)
-		pw.SetSortBy(progress.SortByNone)
-		pw.SetNumTrackersExpected(len(servers))
-		style := progress.StyleVisibilityDefault
-		style.Time = false
-		style.Tracker = false
-		style.Percentage = false
-		pw.SetStyle(progress.Style{
-			Name:       "none",
-			Chars:      progress.StyleChars{},
-			Colors:     progress.StyleColorsExample,
-			Visibility: style,
-			Options:    progress.StyleOptions{},
-		})
-		pw.SetTrackerPosition(progress.PositionRight)
-		pw.SetUpdateFrequency(time.Millisecond * 10)
-
-		wg := sync.WaitGroup{}
-		for name, server := range servers {
-			wg.Add(1)
-			go trackPing(&wg, pw, name, server)
-		}
-
-		pw.Render()
-		wg.Wait()
+	pw := progress.NewWriter()
+	pw.SetAutoStop(true)
+	pw.SetMessageWidth(20)
+	pw.SetSortBy(progress.SortByNone)
+	pw.SetNumTrackersExpected(len(servers))
+	style := progress.StyleVisibilityDefault
+	style.Time = false
+	style.Tracker = false
+	style.Percentage = false
+	pw.SetStyle(progress.Style{
+		Name:       "none",
+		Chars:      progress.StyleChars{},
+		Colors:     progress.StyleColorsExample,
+		Visibility: style,
+		Options:    progress.StyleOptions{},
+	})
+	pw.SetTrackerPosition(progress.PositionRight)
+	pw.SetUpdateFrequency(time.Millisecond * 10)
+
+	wg := sync.WaitGroup{}
+	for name, server := range servers {
+		wg.Add(1)
+		go trackPing(&wg, pw, name, server)
 	}
+
+	pw.Render()
+	wg.Wait()
+	return nil
 }
 
 func trackPing(wg *sync.WaitGroup, pw progress.Writer, name string, server common.Server) {
@@ -110,15 +148,7 @@ func trackPing(wg *sync.WaitGroup, pw progress.Writer, name string, server commo
 	defer tracker.MarkAsDone()
 
 	pw.AppendTracker(&tracker)
-	req := common.MakeRequest(server).SetTimeout(3 * time.Second)
-
-	resp, err := req.R().Get("/version")
-	if err != nil {
-		tracker.SetValue(2)
-		return
-	}
-
-	if resp.StatusCode() != 200 {
+	if err := pingServer(server); err != nil {
 		tracker.SetValue(2)
 		return
 	}
@@ -126,3 +156,55 @@ func trackPing(wg *sync.WaitGroup, pw progress.Writer, name string, server commo
 	time.Sleep(time.Millisecond * 100)
 	tracker.SetValue(1)
 }
+
+// printPing checks all servers at once and prints the result
+// in the selected output format instead of live trackers
+func printPing(servers map[string]common.Server) error {
+	type entry struct {
+		Name  string `json:"name"`
+		URL   string `json:"url"`
+		Alive bool   `json:"alive"`
+		Error string `json:"error,omitempty"`
+	}
+
+	names := make([]string, 0, len(servers))
+	for name := range servers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	entries := make([]entry, len(names))
+	wg := sync.WaitGroup{}
+	for i, name := range names {
+		wg.Add(1)
+		go func(e *entry, server common.Server) {
+			defer wg.Done()
+
+			if err := pingServer(server); err != nil {
+				e.Error = err.Error()
+				return
+			}
+			e.Alive = true
+		}(&entries[i], servers[name])
+
+		u := servers[name].URL()
+		entries[i].Name = name
+		entries[i].URL = u.String()
+	}
+	wg.Wait()
+
+	rows := []table.Row{}
+	for _, e := range entries {
+		rows = append(rows, table.Row{e.Name, e.URL, e.Alive, e.Error})
+	}
+
+	return printResult(entries, table.Row{"Name", "URL", "Alive", "Error"}, rows, table.StyleDefault)
+}
+
+func pingServer(server common.Server) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := newClient(server).Version(ctx)
+	return err
+}
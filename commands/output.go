@@ -0,0 +1,113 @@
+package commands
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/yz3358/clash-ctl/common"
+
+	"github.com/jedib0t/go-pretty/v6/table"
+	"github.com/jedib0t/go-pretty/v6/text"
+	"gopkg.in/yaml.v3"
+)
+
+// Output is the format listing commands print their results in
+var Output = common.FormatTable
+
+// printResult writes v as structured data, or header and rows
+// as a table, depending on the selected output format.
+// Colors are stripped from rows for any format but table.
+func printResult(v any, header table.Row, rows []table.Row, style table.Style) error {
+	switch Output {
+	case common.FormatJSON:
+		buf, err := json.MarshalIndent(v, "", "  ")
+		if err != nil {
+			return err
+		}
+
+		fmt.Println(string(buf))
+	case common.FormatYAML:
+		buf, err := marshalYAML(v)
+		if err != nil {
+			return err
+		}
+
+		fmt.Print(string(buf))
+	case common.FormatTSV:
+		fmt.Println(joinRow(header, "\t"))
+		for _, row := range rows {
+			fmt.Println(joinRow(row, "\t"))
+		}
+	case common.FormatPlain:
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, joinRow(header, "\t"))
+		for _, row := range rows {
+			fmt.Fprintln(w, joinRow(row, "\t"))
+		}
+		return w.Flush()
+	default:
+		renderTable(header, rows, style)
+	}
+
+	return nil
+}
+
+func renderTable(header table.Row, rows []table.Row, style table.Style) string {
+	t := table.NewWriter()
+	t.SetStyle(style)
+	t.SetOutputMirror(os.Stdout)
+	t.AppendHeader(header)
+	t.AppendRows(rows)
+
+	return t.Render()
+}
+
+func joinRow(row table.Row, sep string) string {
+	cells := make([]string, len(row))
+	for i, cell := range row {
+		cells[i] = strings.ReplaceAll(text.StripEscape(fmt.Sprint(cell)), sep, " ")
+	}
+
+	return strings.Join(cells, sep)
+}
+
+// marshalYAML encodes v through its json representation,
+// so both formats share the same field names and order.
+func marshalYAML(v any) ([]byte, error) {
+	buf, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	var node yaml.Node
+	if err := yaml.Unmarshal(buf, &node); err != nil {
+		return nil, err
+	}
+	blockStyle(&node)
+
+	out := &bytes.Buffer{}
+	enc := yaml.NewEncoder(out)
+	enc.SetIndent(2)
+	if err := enc.Encode(&node); err != nil {
+		return nil, err
+	}
+
+	return out.Bytes(), enc.Close()
+}
+
+// blockStyle drops the flow style inherited from json
+func blockStyle(node *yaml.Node) {
+	if node.Kind == yaml.SequenceNode || node.Kind == yaml.MappingNode {
+		node.Style = 0
+	} else if node.Kind == yaml.ScalarNode && node.Style == yaml.DoubleQuotedStyle {
+		node.Style = 0
+	}
+
+	for _, child := range node.Content {
+		blockStyle(child)
+	}
+}
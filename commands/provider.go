@@ -0,0 +1,153 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/yz3358/clash-ctl/api"
+	"github.com/yz3358/clash-ctl/common"
+
+	"github.com/jedib0t/go-pretty/v6/table"
+	"github.com/jedib0t/go-pretty/v6/text"
+)
+
+// GetProxyProviders returns the proxy providers sorted by name,
+// without the built-in one
+func GetProxyProviders() ([]api.ProxyProvider, error) {
+	client, err := defaultClient()
+	if err != nil {
+		return nil, err
+	}
+
+	return proxyProviders(context.Background(), client)
+}
+
+func proxyProviders(ctx context.Context, client *api.Client) ([]api.ProxyProvider, error) {
+	result, err := client.Providers(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	providers := []api.ProxyProvider{}
+	for _, p := range result {
+		if p.VehicleType != api.VehicleTypeCompatible {
+			providers = append(providers, p)
+		}
+	}
+	sort.Slice(providers, func(i, j int) bool { return providers[i].Name < providers[j].Name })
+
+	return providers, nil
+}
+
+func listProxyProviders(a *Args) error {
+	providers, err := GetProxyProviders()
+	if err != nil {
+		return err
+	}
+
+	type entry struct {
+		Name        string `json:"name"`
+		VehicleType string `json:"vehicleType"`
+		UpdatedAt   string `json:"updatedAt"`
+		Size        int    `json:"size"`
+	}
+
+	entries := []entry{}
+	var rows []table.Row
+	for _, p := range providers {
+		entries = append(entries, entry{p.Name, p.VehicleType, p.UpdatedAt, len(p.Proxies)})
+		rows = append(rows, table.Row{p.Name, p.VehicleType, formatUpdatedAt(p.UpdatedAt), len(p.Proxies)})
+	}
+
+	return printResult(entries, table.Row{"Provider", "Vehicle", "Updated", "Nodes"}, rows, table.StyleRounded)
+}
+
+func updateProxyProvider(a *Args) error {
+	name := a.Positional[0]
+	client, err := defaultClient()
+	if err != nil {
+		return err
+	}
+
+	if err := client.UpdateProvider(context.Background(), name); err != nil {
+		return err
+	}
+	resetCompletionCache()
+
+	provider, err := client.Provider(context.Background(), name)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println(text.FgGreen.Sprint("provider updated", markTrue), name, fmt.Sprintf("(%d nodes)", len(provider.Proxies)))
+	return nil
+}
+
+// checkProxyProvider runs the health check of provider,
+// then shows the refreshed delays
+func checkProxyProvider(a *Args) error {
+	name := a.Positional[0]
+	client, err := defaultClient()
+	if err != nil {
+		return err
+	}
+
+	// the health check returns once every proxy is tested
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	if err := client.HealthCheckProvider(ctx, name); err != nil {
+		return err
+	}
+
+	provider, err := client.Provider(context.Background(), name)
+	if err != nil {
+		return err
+	}
+
+	proxies := ProxyList(provider.Proxies)
+	sort.Stable(proxies)
+
+	s := SelectorTable{
+		Selector: Proxy{Name: provider.Name, Type: provider.Type},
+		Proxies:  proxies,
+	}
+	_, err = s.Render()
+	return err
+}
+
+// ProviderResolver completes the provider name of `provider update|check`
+func ProviderResolver(params []string) []common.Node {
+	if len(params) > 1 {
+		return []common.Node{}
+	}
+
+	v, err := getCachedCompletion("providers", func(ctx context.Context, client *api.Client) (any, error) {
+		return proxyProviders(ctx, client)
+	})
+	if err != nil {
+		return []common.Node{}
+	}
+
+	nodes := []common.Node{}
+	for _, p := range v.([]api.ProxyProvider) {
+		nodes = append(nodes, common.Node{
+			Text:        p.Name,
+			Description: fmt.Sprintf("%s, %d nodes", p.VehicleType, len(p.Proxies)),
+		})
+	}
+
+	return nodes
+}
+
+// formatUpdatedAt shows the time passed since an update
+func formatUpdatedAt(updatedAt string) string {
+	t, err := time.Parse(time.RFC3339Nano, updatedAt)
+	if err != nil || t.IsZero() {
+		return "-"
+	}
+
+	return time.Since(t).Round(time.Second).String() + " ago"
+}
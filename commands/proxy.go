@@ -1,86 +1,173 @@
 package commands
 
 import (
+	"context"
 	"fmt"
-	"github.com/jedib0t/go-pretty/v6/text"
-	"github.com/yz3358/clash-ctl/common"
-	"net/url"
 	"sort"
 	"strconv"
-	"strings"
+
+	"github.com/yz3358/clash-ctl/api"
+	"github.com/yz3358/clash-ctl/common"
+
+	"github.com/jedib0t/go-pretty/v6/table"
 )
 
-func HandleProxyCommand(args []string) {
-	if len(args) == 0 {
-		return
+func setProxy(a *Args) error {
+	client, err := defaultClient()
+	if err != nil {
+		return err
 	}
 
-	cfg, err := common.ReadCfg()
+	if err := client.SelectProxy(context.Background(), a.Positional[0], a.Positional[1]); err != nil {
+		return err
+	}
+
+	resetCompletionCache()
+	return nil
+}
+
+func listGroups(a *Args) error {
+	server, err := defaultServer()
 	if err != nil {
-		return
+		return err
+	}
+
+	return listProxyGroups(server.Group)
+}
+
+// groupArg returns the group named by the first positional argument
+// when more than n arguments are given
+func groupArg(a *Args, n int) string {
+	if len(a.Positional) > n {
+		return a.Positional[0]
 	}
 
-	_, server, err := common.GetCurrentServer(cfg)
+	return ""
+}
+
+func listProxies(a *Args) error {
+	group := groupArg(a, 0)
+	s, err := GetSelectorTable(group)
 	if err != nil {
-		fmt.Println(text.FgRed.Sprint(err.Error()))
-		return
+		return err
 	}
 
-	switch args[0] {
-	case "set":
-		req := common.MakeRequest(*server)
-		if len(args) < 3 {
-			fmt.Println(text.FgRed.Sprint("should be `set proxy group proxyName`"))
-			return
-		}
+	if err := rememberGroup(group); err != nil {
+		return err
+	}
 
-		group := url.PathEscape(strings.Replace(args[1], "%20", " ", -1))
-		proxy := strings.Replace(args[2], "%20", " ", -1)
+	_, err = s.Render()
+	return err
+}
 
-		body := map[string]interface{}{
-			"name": proxy,
-		}
-		fail := common.HTTPError{}
+// useProxy switches as `proxy use [group] <id|name>`,
+// names may be fragments matched fuzzily
+func useProxy(a *Args) error {
+	id := "0"
+	if len(a.Positional) > 0 {
+		id = a.Positional[len(a.Positional)-1]
+	}
 
-		resp, err := req.R().SetError(&fail).SetBody(body).Put("/proxies/" + group)
-		if err != nil {
-			fmt.Println(text.FgRed.Sprint(err.Error()))
-			return
-		}
+	group := groupArg(a, 1)
+	s, err := loadedSelectorTable(group)
+	if err != nil {
+		return err
+	}
 
-		if resp.IsError() {
-			fmt.Println(text.FgRed.Sprint(fail.Message))
-		}
-	case "ls":
-		s, err := GetSelectorTable()
-		if err != nil {
-			fmt.Println(err.Error())
-			return
-		}
+	if err := rememberGroup(group); err != nil {
+		return err
+	}
 
-		if _, err = s.Render(); err != nil {
-			fmt.Println(err.Error())
-		}
-	case "use":
-		var id string
-		if len(args) < 2 {
-			id = "0"
-		} else {
-			id = args[1]
-		}
+	if n, err := strconv.Atoi(id); err == nil {
+		return s.Use(n)
+	}
+
+	if n := s.indexOf(id); n >= 0 {
+		return s.Use(n)
+	}
+
+	return s.UseByName(id)
+}
+
+func benchProxies(a *Args) error {
+	server, err := defaultServer()
+	if err != nil {
+		return err
+	}
+
+	group := groupArg(a, 0)
+	s, err := loadedSelectorTable(group)
+	if err != nil {
+		return err
+	}
+
+	if err := rememberGroup(group); err != nil {
+		return err
+	}
+
+	return s.BenchMark(benchOptions(*server, a))
+}
+
+// rememberGroup saves group as the default group of the selected server
+func rememberGroup(group string) error {
+	if group == "" {
+		return nil
+	}
+
+	cfg, err := common.ReadCfg()
+	if err != nil {
+		return err
+	}
+
+	name, server, err := common.GetCurrentServer(cfg)
+	if err != nil {
+		return err
+	}
 
-		if id, err := strconv.Atoi(id); err != nil {
-			fmt.Println(err.Error())
-		} else {
-			err = currentSelector.Use(id)
+	if server.Group == group {
+		return nil
+	}
+
+	server.Group = group
+	cfg.Servers[name] = *server
+	return common.SaveCfg(cfg)
+}
+
+func listProxyGroups(defaultGroup string) error {
+	proxies, err := GetProxies()
+	if err != nil {
+		return err
+	}
+
+	groups := proxyGroups(proxies)
+	if defaultGroup == "" {
+		if g := findDefaultGroup(groups); g != nil {
+			defaultGroup = g.Name
 		}
+	}
+
+	type entry struct {
+		Name    string `json:"name"`
+		Type    string `json:"type"`
+		Now     string `json:"now"`
+		Size    int    `json:"size"`
+		Default bool   `json:"default"`
+	}
 
-		if err != nil {
-			fmt.Println(err.Error())
+	var entries []entry
+	var rows []table.Row
+	for _, g := range groups {
+		name := g.Name
+		if name == defaultGroup {
+			name = fmt.Sprintf("%s <-", name)
 		}
-	case "bench":
-		currentSelector.BenchMark()
+
+		entries = append(entries, entry{g.Name, g.Type, g.Now, len(g.All), g.Name == defaultGroup})
+		rows = append(rows, table.Row{name, g.Type, g.Now, len(g.All)})
 	}
+
+	header := table.Row{"Group", "Type", "Now", "Size"}
+	return printResult(entries, header, rows, table.StyleRounded)
 }
 
 // common proxy values
@@ -92,108 +179,130 @@ var (
 	ProxyNameGlobal = "GLOBAL"
 )
 
-type Proxy struct {
-	Name    string   `json:"name"`
-	Type    string   `json:"type"`
-	Now     string   `json:"now"`
-	All     []string `json:"all"`
-	History []struct {
-		Delay int `json:"delay"`
-	} `json:"history"`
-}
+// Proxy is kept as the name used across commands
+type Proxy = api.Proxy
 
-// LastestDelay returns the last delay
-// recorded in history, 0 means the delay is unknown,
-// should be failed to connect.
-func (p Proxy) LastestDelay() int {
-	l := len(p.History)
-	if l == 0 {
-		return 0
-	}
-	return p.History[l-1].Delay
-}
-
-func (p Proxy) NameEncoded() string {
-	return strings.Replace(p.Name, "%20", " ", -1)
-}
+// ProxySetResolver completes the group and proxy names of `proxy set`
+func ProxySetResolver(params []string) []common.Node {
+	nodes := []common.Node{}
 
-func ProxySetResolver(params []string) (int, []common.Node) {
-	var nodes []common.Node
+	proxies, err := getCachedProxies()
+	if err != nil {
+		return nodes
+	}
 
 	switch len(params) {
 	case 1:
-		proxies, err := GetProxies()
-		if err != nil {
-			return 0, nodes
-		}
 		for name, proxy := range proxies {
-			// fmt.Println("[found proxy]", proxy.Name, proxy.Now)
-			if proxy.Type == "Selector" {
+			if proxy.Type == ProxyTypeSelector {
 				nodes = append(nodes, common.Node{
-					Text:        strings.Replace(name, " ", "%20", -1),
+					Text:        name,
 					Description: fmt.Sprintf("select `%s` now", proxy.Now),
 				})
 			}
 		}
 	case 2:
-		realName := strings.Replace(params[0], "%20", " ", -1)
-		group, err := GetProxyGroup(realName)
-		if err != nil {
-			return 0, nodes
-		}
-		for _, proxy := range group.All {
-			nodes = append(nodes, common.Node{
-				Text: strings.Replace(proxy, " ", "%20", -1),
-			})
+		for _, proxy := range proxies[params[0]].All {
+			nodes = append(nodes, common.Node{Text: proxy})
 		}
 	}
 
 	sort.Slice(nodes, func(i, j int) bool { return nodes[i].Text < nodes[j].Text })
-	return len(params), nodes
+	return nodes
 }
 
-func GetProxies() (map[string]Proxy, error) {
-	cfg, err := common.ReadCfg()
-	if err != nil {
-		return nil, err
+// ProxyGroupResolver completes the group name of `proxy ls|bench`
+func ProxyGroupResolver(params []string) []common.Node {
+	if len(params) > 1 {
+		return []common.Node{}
 	}
 
-	_, server, err := common.GetCurrentServer(cfg)
+	proxies, err := getCachedProxies()
 	if err != nil {
-		return nil, err
+		return []common.Node{}
 	}
 
-	req := common.MakeRequest(*server)
+	return groupNodes(proxies)
+}
 
-	result := struct {
-		Proxies map[string]Proxy `json:"proxies"`
-	}{}
-	_, err = req.R().SetResult(&result).Get("/proxies")
-	if err != nil {
-		return nil, err
+func groupNodes(proxies map[string]Proxy) []common.Node {
+	nodes := []common.Node{}
+	for _, g := range proxyGroups(proxies) {
+		nodes = append(nodes, common.Node{
+			Text:        g.Name,
+			Description: fmt.Sprintf("%s, select `%s` now", g.Type, g.Now),
+		})
 	}
 
-	return result.Proxies, nil
+	return nodes
 }
 
-func GetProxyGroup(group string) (*Proxy, error) {
-	cfg, err := common.ReadCfg()
+// ProxyUseResolver completes `proxy use [group] <id|name>` with the proxies
+// of the group (the loaded or default one when not given) sorted as ProxyList,
+// then the groups
+func ProxyUseResolver(params []string) []common.Node {
+	nodes := []common.Node{}
+	if len(params) > 2 {
+		return nodes
+	}
+
+	name, server, err := selectedServer()
 	if err != nil {
-		return nil, err
+		return nodes
+	}
+
+	proxies, err := getCachedProxies()
+	if err != nil {
+		return nodes
+	}
+
+	var group string
+	if len(params) == 2 {
+		group = params[0]
+	}
+
+	// ids follow the table `proxy use` would pick,
+	// the loaded one only if it belongs to the selected server
+	s := &currentSelector
+	if !selectorLoaded(name) || (group != "" && group != s.Selector.Name) {
+		if s, err = newSelectorTable(proxies, group, server.Group); err != nil {
+			return nodes
+		}
+	}
+
+	for id, proxy := range s.Proxies {
+		delay := "-"
+		if d := proxy.LastestDelay(); d > 0 {
+			delay = fmt.Sprintf("%dms", d)
+		}
+
+		description := fmt.Sprintf("id %d, %s", id, delay)
+		if proxy.Name == s.Selector.Now {
+			description += ", now"
+		}
+		nodes = append(nodes, common.Node{Text: proxy.Name, Description: description})
 	}
 
-	_, server, err := common.GetCurrentServer(cfg)
+	if len(params) == 1 {
+		nodes = append(nodes, groupNodes(proxies)...)
+	}
+	return nodes
+}
+
+func GetProxies() (map[string]Proxy, error) {
+	client, err := defaultClient()
 	if err != nil {
 		return nil, err
 	}
 
-	req := common.MakeRequest(*server)
+	return client.Proxies(context.Background())
+}
 
-	result := &Proxy{}
-	_, err = req.R().SetResult(result).Get("/proxies/" + url.PathEscape(group))
+func GetProxyGroup(group string) (*Proxy, error) {
+	client, err := defaultClient()
 	if err != nil {
 		return nil, err
 	}
 
-	return result, nil
+	return client.Proxy(context.Background(), group)
 }
@@ -0,0 +1,73 @@
+package commands
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/yz3358/clash-ctl/api"
+)
+
+// completionCacheTTL is how long completion reuses what it fetched from a server
+var completionCacheTTL = 5 * time.Second
+
+type cachedCompletion struct {
+	at    time.Time
+	value any
+}
+
+var (
+	completionCacheMu sync.Mutex
+	completionCache   = map[string]cachedCompletion{}
+)
+
+// getCachedCompletion returns what fetch returns for the selected server,
+// reusing the result of the same kind fetched at most completionCacheTTL ago,
+// so completion does not hit the controller per keystroke
+func getCachedCompletion(kind string, fetch func(ctx context.Context, client *api.Client) (any, error)) (any, error) {
+	name, server, err := selectedServer()
+	if err != nil {
+		return nil, err
+	}
+
+	key := name + "/" + kind
+	completionCacheMu.Lock()
+	defer completionCacheMu.Unlock()
+
+	if c, ok := completionCache[key]; ok && time.Since(c.at) < completionCacheTTL {
+		return c.value, nil
+	}
+
+	// completion should not block the prompt for long
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	value, err := fetch(ctx, newClient(*server))
+	if err != nil {
+		return nil, err
+	}
+
+	completionCache[key] = cachedCompletion{at: time.Now(), value: value}
+	return value, nil
+}
+
+// getCachedProxies returns the proxies of the selected server for completion
+func getCachedProxies() (map[string]Proxy, error) {
+	v, err := getCachedCompletion("proxies", func(ctx context.Context, client *api.Client) (any, error) {
+		return client.Proxies(ctx)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return v.(map[string]Proxy), nil
+}
+
+// resetCompletionCache drops everything cached for completion,
+// e.g. after the proxies or providers changed
+func resetCompletionCache() {
+	completionCacheMu.Lock()
+	defer completionCacheMu.Unlock()
+
+	completionCache = map[string]cachedCompletion{}
+}
@@ -0,0 +1,338 @@
+package commands
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/yz3358/clash-ctl/common"
+)
+
+// Command declares a command once,
+// both dispatch and completion are generated from it.
+type Command struct {
+	Name    string
+	Aliases []string
+	// Args is the usage of positional arguments, e.g. "[group] <id|name>"
+	Args string
+	// MinArgs is the number of positional arguments required
+	MinArgs int
+	Help    string
+	Flags   []Flag
+	// Resolver completes the positional arguments,
+	// the last one of args is the one being typed
+	Resolver    func(args []string) []common.Node
+	Handler     func(a *Args) error
+	Subcommands []*Command
+}
+
+// Flag declares a flag of a command, its type
+// follows Default (string, bool, int, int64 or time.Duration)
+type Flag struct {
+	Name    string
+	Usage   string
+	Default any
+	// Resolver completes the value of the flag
+	Resolver func() []common.Node
+}
+
+// Args are the parsed arguments of a command
+type Args struct {
+	Positional []string
+	flags      *flag.FlagSet
+}
+
+func (a *Args) value(name string) any {
+	f := a.flags.Lookup(name)
+	if f == nil {
+		panic("flag not declared: " + name)
+	}
+
+	return f.Value.(flag.Getter).Get()
+}
+
+func (a *Args) String(name string) string          { return a.value(name).(string) }
+func (a *Args) Bool(name string) bool              { return a.value(name).(bool) }
+func (a *Args) Int(name string) int                { return a.value(name).(int) }
+func (a *Args) Int64(name string) int64            { return a.value(name).(int64) }
+func (a *Args) Duration(name string) time.Duration { return a.value(name).(time.Duration) }
+
+// Has reports whether the command declares the flag
+func (a *Args) Has(name string) bool {
+	return a.flags.Lookup(name) != nil
+}
+
+// path returns the full name of cmd under its parents, e.g. "proxy ls"
+func path(parents []*Command, cmd *Command) string {
+	names := []string{}
+	for _, p := range parents {
+		names = append(names, p.Name)
+	}
+
+	return strings.Join(append(names, cmd.Name), " ")
+}
+
+func (c *Command) matches(name string) bool {
+	if c.Name == name {
+		return true
+	}
+
+	for _, alias := range c.Aliases {
+		if alias == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (c *Command) isBool(name string) bool {
+	for _, f := range c.Flags {
+		if f.Name == name {
+			_, ok := f.Default.(bool)
+			return ok
+		}
+	}
+
+	return false
+}
+
+func (c *Command) flagSet(name string) *flag.FlagSet {
+	fs := newFlagSet(name)
+	for _, f := range c.Flags {
+		switch v := f.Default.(type) {
+		case string:
+			fs.String(f.Name, v, f.Usage)
+		case bool:
+			fs.Bool(f.Name, v, f.Usage)
+		case int:
+			fs.Int(f.Name, v, f.Usage)
+		case int64:
+			fs.Int64(f.Name, v, f.Usage)
+		case time.Duration:
+			fs.Duration(f.Name, v, f.Usage)
+		default:
+			panic(fmt.Sprintf("unsupported default of flag %s: %T", f.Name, f.Default))
+		}
+	}
+
+	return fs
+}
+
+func findCommand(cmds []*Command, name string) *Command {
+	for _, c := range cmds {
+		if c.matches(name) {
+			return c
+		}
+	}
+
+	return nil
+}
+
+// lookup descends cmds along words as far as they name commands,
+// returning the last command matched, its parents and the remaining words
+func lookup(cmds []*Command, words []string) (*Command, []*Command, []string) {
+	var cmd *Command
+	var parents []*Command
+
+	for i, w := range words {
+		next := findCommand(cmds, w)
+		if next == nil {
+			return cmd, parents, words[i:]
+		}
+
+		if cmd != nil {
+			parents = append(parents, cmd)
+		}
+		cmd, cmds = next, next.Subcommands
+	}
+
+	return cmd, parents, nil
+}
+
+// Execute dispatches args (a command line split in words) to its handler
+func Execute(args []string) error {
+	if len(args) == 0 {
+		return common.NewUsageError("should input a command, see `help`")
+	}
+
+	cmd, parents, rest := lookup(Root, args)
+	if cmd == nil {
+		return common.NewUsageError("unknown command %s, see `help`", args[0])
+	}
+
+	name := path(parents, cmd)
+	unknown := len(cmd.Subcommands) > 0 && len(rest) > 0 && !strings.HasPrefix(rest[0], "-")
+	if cmd.Handler == nil || unknown {
+		if len(rest) > 0 {
+			return common.NewUsageError("unknown command %s %s, see `help %s`", name, rest[0], name)
+		}
+
+		return common.NewUsageError("should be `%s %s`, see `help %s`", name, subcommandNames(cmd), name)
+	}
+
+	fs := cmd.flagSet(name)
+	if err := parseFlags(fs, rest); err != nil {
+		return err
+	}
+
+	a := &Args{Positional: fs.Args(), flags: fs}
+	if len(a.Positional) < cmd.MinArgs {
+		return common.NewUsageError("should be `%s %s`", name, cmd.Args)
+	}
+
+	return cmd.Handler(a)
+}
+
+func subcommandNames(cmd *Command) string {
+	names := []string{}
+	for _, sub := range cmd.Subcommands {
+		names = append(names, sub.Name)
+	}
+
+	return strings.Join(names, "|")
+}
+
+// Complete suggests the next word of words (a command line split in words),
+// the last word is the one being typed
+func Complete(words []string) []common.Node {
+	nodes := []common.Node{}
+	if len(words) == 0 {
+		return nodes
+	}
+
+	typed, current := words[:len(words)-1], words[len(words)-1]
+
+	cmd, _, rest := lookup(Root, typed)
+	if cmd == nil {
+		if len(typed) == 0 {
+			return commandNodes(Root)
+		}
+		return nodes
+	}
+
+	// --- value of a flag
+	if len(rest) > 0 {
+		prev := rest[len(rest)-1]
+		if name := strings.TrimLeft(prev, "-"); strings.HasPrefix(prev, "-") && !strings.Contains(name, "=") && !cmd.isBool(name) {
+			for _, f := range cmd.Flags {
+				if f.Name == name && f.Resolver != nil {
+					return f.Resolver()
+				}
+			}
+			return nodes
+		}
+	}
+
+	// --- flags
+	if strings.HasPrefix(current, "-") {
+		for _, f := range cmd.Flags {
+			nodes = append(nodes, common.Node{Text: "--" + f.Name, Description: f.Usage})
+		}
+		return nodes
+	}
+
+	// --- sub commands, then positional arguments
+	if len(rest) == 0 {
+		nodes = append(nodes, commandNodes(cmd.Subcommands)...)
+	}
+
+	if cmd.Resolver != nil {
+		var positional []string
+		for i := 0; i < len(rest); i++ {
+			if strings.HasPrefix(rest[i], "-") {
+				name := strings.TrimLeft(rest[i], "-")
+				if !strings.Contains(name, "=") && !cmd.isBool(name) {
+					i++
+				}
+				continue
+			}
+			positional = append(positional, rest[i])
+		}
+
+		nodes = append(nodes, cmd.Resolver(append(positional, current))...)
+	}
+
+	return nodes
+}
+
+func commandNodes(cmds []*Command) []common.Node {
+	nodes := []common.Node{}
+	for _, c := range cmds {
+		nodes = append(nodes, common.Node{Text: c.Name, Description: c.Help})
+	}
+
+	return nodes
+}
+
+// help prints the usage of the command named by args with its flags,
+// or of all commands
+func help(a *Args) error {
+	if len(a.Positional) > 0 {
+		cmd, ps, rest := lookup(Root, a.Positional)
+		if cmd == nil || len(rest) > 0 {
+			return common.NewUsageError("unknown command %s", strings.Join(a.Positional, " "))
+		}
+
+		printUsage(ps, cmd, true)
+		for _, sub := range cmd.Subcommands {
+			printUsage(append(ps, cmd), sub, true)
+		}
+		return nil
+	}
+
+	for _, c := range Root {
+		printUsage(nil, c, false)
+		for _, sub := range c.Subcommands {
+			printUsage([]*Command{c}, sub, false)
+		}
+	}
+
+	return nil
+}
+
+// helpResolver completes the command names of `help`
+func helpResolver(params []string) []common.Node {
+	if len(params) == 1 {
+		return commandNodes(Root)
+	}
+
+	cmd, _, rest := lookup(Root, params[:len(params)-1])
+	if cmd == nil || len(rest) > 0 {
+		return []common.Node{}
+	}
+
+	return commandNodes(cmd.Subcommands)
+}
+
+func printUsage(parents []*Command, cmd *Command, withFlags bool) {
+	usage := path(parents, cmd)
+	if cmd.Args != "" {
+		usage += " " + cmd.Args
+	}
+	if len(cmd.Flags) > 0 {
+		usage += " [flags]"
+	}
+
+	if cmd.Handler == nil {
+		usage += " ..."
+	}
+
+	fmt.Printf("  %-36s %s\n", usage, cmd.Help)
+	if len(cmd.Aliases) > 0 {
+		fmt.Printf("  %-36s alias: %s\n", "", strings.Join(cmd.Aliases, ", "))
+	}
+
+	if !withFlags {
+		return
+	}
+
+	for _, f := range cmd.Flags {
+		flagUsage := "--" + f.Name
+		if v := fmt.Sprint(f.Default); v != "false" && v != "" && v != "0" && v != "0s" {
+			flagUsage += fmt.Sprintf(" (default %v)", f.Default)
+		}
+		fmt.Printf("      %-32s %s\n", flagUsage, f.Usage)
+	}
+}
@@ -0,0 +1,237 @@
+package commands
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+// Root is the command tree of clash-ctl
+var Root = []*Command{
+	{
+		Name: "proxy", Help: "manage remote clash proxy",
+		Subcommands: []*Command{
+			{
+				Name: "set", Args: "<group> <proxy>", MinArgs: 2,
+				Help:     "(set by name) select a proxy from a group",
+				Resolver: ProxySetResolver, Handler: setProxy,
+			},
+			{Name: "groups", Help: "list all proxy groups", Handler: listGroups},
+			{
+				Name: "ls", Args: "[group]",
+				Help:     "list avaliable proxies of a group with ids",
+				Resolver: ProxyGroupResolver, Handler: listProxies,
+			},
+			{
+				Name: "use", Args: "[group] <id|name>",
+				Help:     "use proxy by id or name, optionally in a group",
+				Resolver: ProxyUseResolver, Handler: useProxy,
+			},
+			{
+				Name: "bench", Args: "[group]",
+				Help:     "benchmark a group, showing latency, jitter and loss",
+				Flags:    benchFlags,
+				Resolver: ProxyGroupResolver, Handler: benchProxies,
+			},
+			{
+				Name: "auto", Args: "[group]",
+				Help:     "benchmark a group and switch to the fastest allowed proxy",
+				Flags:    append(append([]Flag{}, benchFlags...), autoFlags...),
+				Resolver: ProxyGroupResolver, Handler: autoProxy,
+			},
+		},
+	},
+	{
+		Name: "mode", Args: "[rule|global|direct]",
+		Help:     "show or change proxy mode",
+		Resolver: ModeResolver, Handler: setMode,
+	},
+	{
+		Name: "config", Help: "inspect and patch runtime config",
+		Subcommands: []*Command{
+			{Name: "show", Help: "show the running config", Handler: showConfig},
+			{
+				Name: "set", Args: "<key> <value>", MinArgs: 2,
+				Help:     "set a config key",
+				Resolver: ConfigSetResolver, Handler: setConfig,
+			},
+		},
+	},
+	{Name: "now", Help: "show selected clash server", Handler: showServer},
+	{
+		Name: "output", Args: "[format]",
+		Help:     "show or change output format",
+		Resolver: OutputResolver, Handler: setOutput,
+	},
+	{Name: "ping", Help: "check clash servers alive", Handler: ping},
+	{
+		Name: "provider", Help: "manage proxy providers",
+		Subcommands: []*Command{
+			{Name: "ls", Help: "list proxy providers", Handler: listProxyProviders},
+			{
+				Name: "update", Args: "<provider>", MinArgs: 1,
+				Help:     "refresh a provider subscription",
+				Resolver: ProviderResolver, Handler: updateProxyProvider,
+			},
+			{
+				Name: "check", Args: "<provider>", MinArgs: 1,
+				Help:     "health check a provider and show delays",
+				Resolver: ProviderResolver, Handler: checkProxyProvider,
+			},
+		},
+	},
+	{
+		Name: "rules", Help: "browse the rules of running config",
+		Subcommands: []*Command{
+			{
+				Name: "ls", Args: "[search]",
+				Help: "list rules with search and paging",
+				Flags: []Flag{
+					{Name: "search", Default: "", Usage: "only show rules whose type or payload contain the text"},
+					{Name: "regex", Default: false, Usage: "treat the search text as a regex"},
+					{Name: "proxy", Default: "", Usage: "only show rules pointing to the policy", Resolver: PolicyResolver},
+					{Name: "page", Default: 1, Usage: "page to show"},
+					{Name: "page-size", Default: 50, Usage: "rules per page, 0 for all"},
+				},
+				Handler: listRules,
+			},
+		},
+	},
+	{
+		Name: "ruleset", Help: "manage rule providers",
+		Subcommands: []*Command{
+			{Name: "ls", Help: "list rule providers", Handler: listRuleProviders},
+			{
+				Name: "update", Args: "<provider...>",
+				Help:     "refresh rule providers by name or --all",
+				Flags:    []Flag{{Name: "all", Default: false, Usage: "update all rule providers"}},
+				Resolver: RulesetResolver, Handler: updateRuleProviders,
+			},
+		},
+	},
+	{
+		Name: "watch", Args: "[group...]",
+		Help:     "fail over selectors whose selected proxy keeps failing",
+		Flags:    watchCommandFlags(),
+		Resolver: WatchResolver, Handler: watchGroups,
+	},
+	{
+		Name: "traffic", Help: "graph clash traffic with session stats",
+		Flags: trafficFlags, Handler: showTraffic,
+	},
+	{
+		Name: "logs", Args: "[level]",
+		Help: "stream clash logs of a level",
+		Flags: []Flag{
+			{Name: "include", Default: "", Usage: "only show entries matching the regex"},
+			{Name: "exclude", Default: "", Usage: "hide entries matching the regex"},
+			{Name: "save", Default: "", Usage: "also append entries to the file"},
+			{Name: "max-size", Default: int64(10), Usage: "rotate the saved file after it reaches the size in MB"},
+			{Name: "backups", Default: 3, Usage: "number of rotated files to keep"},
+		},
+		Resolver: LogLevelResolver, Handler: streamLogs,
+	},
+	{
+		Name: "connections", Help: "list connections with filters, sorting and columns",
+		Flags:   append(connectionViewFlags("host,network,type,chain,rule,time"), connectionFilterFlags...),
+		Handler: listConnections,
+		Subcommands: []*Command{
+			{
+				Name: "watch", Help: "monitor connections with live rates",
+				Flags: []Flag{
+					{Name: "interval", Default: time.Second, Usage: "refresh interval"},
+					{Name: "sort", Default: "rate", Usage: "sort by rate, total, duration or host", Resolver: sortKeyResolver},
+					{Name: "limit", Default: 30, Usage: "max rows to show, 0 for all"},
+				},
+				Handler: watchConnections,
+			},
+			{
+				Name: "top", Help: "aggregate traffic by host, rule or proxy",
+				Flags: append([]Flag{
+					{Name: "by", Default: "host", Usage: "aggregate by host, rule or proxy", Resolver: groupKeyResolver},
+					{Name: "window", Default: 10 * time.Second, Usage: "sampling window of the rates"},
+					{Name: "interval", Default: time.Second, Usage: "refresh interval"},
+					{Name: "duration", Default: time.Duration(0), Usage: "print once after sampling this long, 0 to redraw until interrupted"},
+					{Name: "limit", Default: 20, Usage: "max rows to show, 0 for all"},
+				}, connectionFilterFlags...),
+				Handler: topConnections,
+			},
+			{
+				Name: "record", Args: "<file>", MinArgs: 1,
+				Help: "record connections to a rotated JSON lines file",
+				Flags: append([]Flag{
+					{Name: "interval", Default: time.Second, Usage: "snapshot interval"},
+					{Name: "max-size", Default: int64(10), Usage: "rotate the file after it reaches the size in MB"},
+					{Name: "backups", Default: 3, Usage: "number of rotated files to keep"},
+					{Name: "checkpoint", Default: time.Minute, Usage: "also write the open connections this often, 0 for only once closed"},
+				}, connectionFilterFlags...),
+				Handler: recordConnections,
+			},
+			{
+				Name: "replay", Args: "<file...>", MinArgs: 1,
+				Help: "filter, sort or aggregate recorded connections",
+				Flags: append(append(connectionViewFlags("host,network,chain,rule,total,first,last"),
+					Flag{Name: "by", Default: "", Usage: "aggregate by host, rule or proxy", Resolver: groupKeyResolver},
+					Flag{Name: "limit", Default: 20, Usage: "max rows to show when aggregating, 0 for all"},
+					Flag{Name: "since", Default: "", Usage: "only connections alive after the time (2006-01-02 15:04)"},
+					Flag{Name: "until", Default: "", Usage: "only connections alive before the time"},
+				), connectionFilterFlags...),
+				Handler: replayConnections,
+			},
+			{
+				Name: "close", Args: "[id...]",
+				Help: "close connections by id, filters or --all",
+				Flags: append([]Flag{
+					{Name: "all", Default: false, Usage: "close all connections"},
+					{Name: "yes", Default: false, Usage: "close without confirmation"},
+					{Name: "y", Default: false, Usage: "shorthand for --yes"},
+				}, connectionFilterFlags...),
+				Handler: closeConnections,
+			},
+		},
+	},
+	{
+		Name: "exporter", Help: "serve prometheus metrics of all servers",
+		Flags: exporterFlags, Handler: exportMetrics,
+	},
+	{
+		Name: "server", Help: "manage remote clash server",
+		Subcommands: []*Command{
+			{Name: "ls", Help: "list all server", Handler: listServers},
+			{Name: "add", Help: "add new server", Handler: addServer},
+			{
+				Name: "rm", Args: "<server>", MinArgs: 1,
+				Help:     "rm a server",
+				Resolver: UseServerResolver, Handler: removeServer,
+			},
+			useCommand,
+		},
+	},
+	useCommand,
+}
+
+var useCommand = &Command{
+	Name: "use", Args: "<server>", MinArgs: 1,
+	Help:     "change selected clash server",
+	Resolver: UseServerResolver, Handler: useServer,
+}
+
+// help and exit refer to Root, so they are added on init
+func init() {
+	Root = append(Root,
+		&Command{
+			Name: "help", Aliases: []string{"?"}, Args: "[command]",
+			Help:     "show usage of commands",
+			Resolver: helpResolver, Handler: help,
+		},
+		&Command{
+			Name: "exit", Aliases: []string{"quit"},
+			Help: "exit clash-ctl",
+			Handler: func(a *Args) error {
+				fmt.Println("Bye!")
+				os.Exit(0)
+				return nil
+			},
+		},
+	)
+}
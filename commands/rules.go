@@ -0,0 +1,127 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/yz3358/clash-ctl/api"
+	"github.com/yz3358/clash-ctl/common"
+
+	"github.com/jedib0t/go-pretty/v6/table"
+	"github.com/jedib0t/go-pretty/v6/text"
+)
+
+func GetRules() ([]api.Rule, error) {
+	client, err := defaultClient()
+	if err != nil {
+		return nil, err
+	}
+
+	return client.Rules(context.Background())
+}
+
+// listRules prints rules as `rules ls [search] [flags]`
+func listRules(a *Args) error {
+	search, useRegex, proxy := a.String("search"), a.Bool("regex"), a.String("proxy")
+	page, pageSize := a.Int("page"), a.Int("page-size")
+
+	if search == "" {
+		search = strings.Join(a.Positional, " ")
+	}
+
+	match := func(r api.Rule) bool { return true }
+	if search != "" {
+		if useRegex {
+			re, err := regexp.Compile(search)
+			if err != nil {
+				return common.NewUsageError("invalid search pattern: %s", err.Error())
+			}
+			match = func(r api.Rule) bool { return re.MatchString(r.Type) || re.MatchString(r.Payload) }
+		} else {
+			keyword := strings.ToLower(search)
+			match = func(r api.Rule) bool {
+				return strings.Contains(strings.ToLower(r.Type), keyword) || strings.Contains(strings.ToLower(r.Payload), keyword)
+			}
+		}
+	}
+
+	rules, err := GetRules()
+	if err != nil {
+		return err
+	}
+
+	type entry struct {
+		Index int `json:"index"`
+		api.Rule
+	}
+
+	matched := []entry{}
+	for i, r := range rules {
+		if proxy != "" && r.Proxy != proxy {
+			continue
+		}
+		if match(r) {
+			matched = append(matched, entry{i, r})
+		}
+	}
+
+	// --- pick the page
+	pages := 1
+	if pageSize > 0 && len(matched) > 0 {
+		pages = (len(matched) + pageSize - 1) / pageSize
+	}
+	if page < 1 || page > pages {
+		return common.NewUsageError("page %d out of range 1-%d", page, pages)
+	}
+
+	shown := matched
+	if pageSize > 0 {
+		start := (page - 1) * pageSize
+		end := start + pageSize
+		if end > len(matched) {
+			end = len(matched)
+		}
+		shown = matched[start:end]
+	}
+
+	var rows []table.Row
+	for _, e := range shown {
+		rows = append(rows, table.Row{e.Index, e.Type, e.Payload, text.FgCyan.Sprint(e.Proxy)})
+	}
+
+	if err := printResult(shown, table.Row{"Index", "Type", "Payload", "Proxy"}, rows, table.StyleRounded); err != nil {
+		return err
+	}
+
+	if Output == common.FormatTable {
+		fmt.Printf("page %d/%d, %d of %d rules matched\n", page, pages, len(matched), len(rules))
+	}
+
+	return nil
+}
+
+// PolicyResolver completes the policy names of `rules ls --proxy`
+func PolicyResolver() []common.Node {
+	nodes := []common.Node{}
+	v, err := getCachedCompletion("rules", func(ctx context.Context, client *api.Client) (any, error) {
+		return client.Rules(ctx)
+	})
+	if err != nil {
+		return nodes
+	}
+	rules := v.([]api.Rule)
+
+	seen := map[string]bool{}
+	for _, r := range rules {
+		if !seen[r.Proxy] {
+			seen[r.Proxy] = true
+			nodes = append(nodes, common.Node{Text: r.Proxy, Description: "policy"})
+		}
+	}
+
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].Text < nodes[j].Text })
+	return nodes
+}
@@ -0,0 +1,113 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/yz3358/clash-ctl/api"
+	"github.com/yz3358/clash-ctl/common"
+
+	"github.com/jedib0t/go-pretty/v6/table"
+	"github.com/jedib0t/go-pretty/v6/text"
+)
+
+// GetRuleProviders returns the rule providers sorted by name
+func GetRuleProviders() ([]api.RuleProvider, error) {
+	client, err := defaultClient()
+	if err != nil {
+		return nil, err
+	}
+
+	return ruleProviders(context.Background(), client)
+}
+
+func ruleProviders(ctx context.Context, client *api.Client) ([]api.RuleProvider, error) {
+	result, err := client.RuleProviders(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	providers := []api.RuleProvider{}
+	for _, p := range result {
+		providers = append(providers, p)
+	}
+	sort.Slice(providers, func(i, j int) bool { return providers[i].Name < providers[j].Name })
+
+	return providers, nil
+}
+
+func listRuleProviders(a *Args) error {
+	providers, err := GetRuleProviders()
+	if err != nil {
+		return err
+	}
+
+	var rows []table.Row
+	for _, p := range providers {
+		rows = append(rows, table.Row{p.Name, p.Behavior, p.RuleCount, p.VehicleType, formatUpdatedAt(p.UpdatedAt)})
+	}
+
+	header := table.Row{"Rule Provider", "Behavior", "Rules", "Vehicle", "Updated"}
+	return printResult(providers, header, rows, table.StyleRounded)
+}
+
+// updateRuleProviders refreshes providers named in args, or all of them with --all
+func updateRuleProviders(a *Args) error {
+	names := a.Positional
+	if len(names) == 0 && !a.Bool("all") {
+		return common.NewUsageError("should input rule provider name or --all")
+	}
+
+	if a.Bool("all") {
+		providers, err := GetRuleProviders()
+		if err != nil {
+			return err
+		}
+
+		names = nil
+		for _, p := range providers {
+			names = append(names, p.Name)
+		}
+	}
+
+	client, err := defaultClient()
+	if err != nil {
+		return err
+	}
+
+	var lastErr error
+	for _, name := range names {
+		err := client.UpdateRuleProvider(context.Background(), name)
+		if err != nil {
+			fmt.Println(name, text.FgRed.Sprint(err.Error()))
+			lastErr = err
+			continue
+		}
+
+		fmt.Println(text.FgGreen.Sprint("rule provider updated", markTrue), name)
+	}
+	resetCompletionCache()
+
+	return lastErr
+}
+
+// RulesetResolver completes the rule provider names of `ruleset update`
+func RulesetResolver(params []string) []common.Node {
+	v, err := getCachedCompletion("rulesets", func(ctx context.Context, client *api.Client) (any, error) {
+		return ruleProviders(ctx, client)
+	})
+	if err != nil {
+		return []common.Node{}
+	}
+
+	nodes := []common.Node{}
+	for _, p := range v.([]api.RuleProvider) {
+		nodes = append(nodes, common.Node{
+			Text:        p.Name,
+			Description: fmt.Sprintf("%s, %d rules", p.Behavior, p.RuleCount),
+		})
+	}
+
+	return nodes
+}
@@ -1,23 +1,23 @@
 package commands
 
 import (
-	"encoding/json"
+	"context"
 	"errors"
 	"fmt"
 	"github.com/jedib0t/go-pretty/v6/table"
 	"github.com/jedib0t/go-pretty/v6/text"
+	"github.com/yz3358/clash-ctl/api"
 	"github.com/yz3358/clash-ctl/common"
-	"log"
 	"math"
-	"net/url"
-	"os"
 	"sort"
-	"sync"
 )
 
 var currentSelector SelectorTable
 var maxRendered = 60
 
+// currentSelectorServer is the name of the server currentSelector belongs to
+var currentSelectorServer string
+
 var (
 	markTrue  = "✓"
 	markFalse = "✗"
@@ -40,26 +40,24 @@ func (s SelectorTable) Render() (string, error) {
 
 	// fmt.Println("proxy now", s.Selector.Now)
 
-	t := table.NewWriter()
-	t.SetStyle(table.StyleRounded)
-	t.SetOutputMirror(os.Stdout)
-	t.AppendHeader(table.Row{"Id", "Selector", "Proxy Name", "Delay"})
+	type entry struct {
+		ID       int    `json:"id"`
+		Group    string `json:"group"`
+		Name     string `json:"name"`
+		Type     string `json:"type"`
+		Now      string `json:"now,omitempty"`
+		Delay    int    `json:"delay"`
+		Selected bool   `json:"selected"`
+	}
 
+	var entries []entry
 	var rows []table.Row
 	for id, proxy := range s.Proxies {
-		if id > maxRendered {
-			break
-		}
-
-		delay := markFalse
-		formatter := text.FgRed
+		entries = append(entries, entry{id, s.Selector.Name, proxy.Name, proxy.Type, proxy.Now, proxy.LastestDelay(), s.Selector.Now == proxy.Name})
 
-		if d := proxy.LastestDelay(); d > 0 {
-			formatter = text.FgGreen
-			if d > 500 {
-				formatter = text.FgYellow
-			}
-			delay = fmt.Sprintf("%vms", d)
+		// only the table is cut, structured output lists every proxy
+		if id > maxRendered {
+			continue
 		}
 
 		idStr := fmt.Sprintf("%v", id)
@@ -67,7 +65,7 @@ func (s SelectorTable) Render() (string, error) {
 			idStr = fmt.Sprintf("%v <-", id)
 		}
 
-		delay = formatter.Sprint(delay)
+		delay := formatDelay(proxy.LastestDelay())
 
 		proxyName := proxy.Name
 		if proxy.Now != "" {
@@ -76,9 +74,26 @@ func (s SelectorTable) Render() (string, error) {
 
 		rows = append(rows, []any{idStr, s.Selector.Name, proxyName, delay})
 	}
-	t.AppendRows(rows)
 
-	return t.Render(), nil
+	header := table.Row{"Id", "Selector", "Proxy Name", "Delay"}
+	if Output == common.FormatTable {
+		return renderTable(header, rows, table.StyleRounded), nil
+	}
+
+	return "", printResult(entries, header, rows, table.StyleRounded)
+}
+
+// formatDelay colors a delay in ms, 0 means the test failed
+func formatDelay(d int) string {
+	if d <= 0 {
+		return text.FgRed.Sprint(markFalse)
+	}
+
+	formatter := text.FgGreen
+	if d > 500 {
+		formatter = text.FgYellow
+	}
+	return formatter.Sprintf("%vms", d)
 }
 
 // Use proxy based on id
@@ -88,29 +103,20 @@ func (s SelectorTable) Use(id int) error {
 		return errors.New("id out of range")
 	}
 
-	server, err := defaultServer()
+	client, err := defaultClient()
 	if err != nil {
 		return err
 	}
 
-	body := map[string]any{
-		"name": proxy.NameEncoded(),
-	}
-	group := url.PathEscape(s.Selector.NameEncoded())
-
-	req := common.MakeRequest(*server)
-	fail := common.HTTPError{}
-	resp, err := req.R().SetError(&fail).SetBody(body).Put("/proxies/" + group)
-	if err != nil {
-		return fmt.Errorf("%s", text.FgRed.Sprint(err.Error()))
+	if err := client.SelectProxy(context.Background(), s.Selector.Name, proxy.Name); err != nil {
+		return err
 	}
+	resetCompletionCache()
 
-	if resp.IsError() {
-		return fmt.Errorf("%s", text.FgRed.Sprint(fail.Message))
+	if Output == common.FormatTable {
+		fmt.Println(text.FgGreen.Sprint("proxy switched", markTrue), proxy.Name)
 	}
 
-	fmt.Println(text.FgGreen.Sprint("proxy switched", markTrue), proxy.Name)
-
 	return nil
 }
 
@@ -124,32 +130,6 @@ func (s SelectorTable) findProxy(id int) *Proxy {
 	return &(s.Proxies[id])
 }
 
-func (s SelectorTable) BenchMark() {
-	if s.Selector.Name == "" {
-		fmt.Println(text.FgRed.Sprint(ErrSelectorNotInitialized.Error()))
-		return
-	}
-
-	var wg sync.WaitGroup
-	for _, proxy := range s.Proxies {
-		wg.Add(1)
-		go func(p Proxy) {
-			err := getProxyDelay(p)
-			if err != nil {
-				fmt.Println(p.Name, err.Error())
-			}
-			wg.Done()
-		}(proxy)
-	}
-
-	wg.Wait()
-
-	log.Println(text.FgGreen.Sprint("benchmark test finished\n"))
-
-	GetSelectorTable()
-	currentSelector.Render()
-}
-
 type ProxyList []Proxy
 
 func (l ProxyList) Len() int {
@@ -171,21 +151,47 @@ func (l ProxyList) Less(i, j int) bool {
 	return a < b
 }
 
-func GetSelectorTable() (*SelectorTable, error) {
-	var proxyList ProxyList
+// GetSelectorTable lists the proxies of group sorted by delay,
+// an empty group means the default group of the selected server.
+func GetSelectorTable(group string) (*SelectorTable, error) {
+	name, server, err := selectedServer()
+	if err != nil {
+		return nil, err
+	}
 
-	proxies, err := GetProxies()
+	proxies, err := newClient(*server).Proxies(context.Background())
 	if err != nil {
 		return nil, err
 	}
 
-	// --- get rule-based selector
+	s, err := newSelectorTable(proxies, group, server.Group)
+	if err != nil {
+		return nil, err
+	}
+
+	currentSelector, currentSelectorServer = *s, name
+	return &currentSelector, nil
+}
+
+// newSelectorTable lists the proxies of group in proxies sorted by delay,
+// an empty group means defaultGroup, then the first rule-based selector
+func newSelectorTable(proxies map[string]Proxy, group, defaultGroup string) (*SelectorTable, error) {
+	var proxyList ProxyList
+
+	if group == "" {
+		group = defaultGroup
+	}
+
+	// --- get the group, fallback to the first rule-based selector
 	var selector *Proxy
-	for _, proxy := range proxies {
-		if proxy.Type == ProxyTypeSelector && proxy.Name != ProxyNameGlobal {
-			selector = &proxy
-			break
+	if group != "" {
+		proxy, ok := proxies[group]
+		if !ok || len(proxy.All) == 0 {
+			return nil, common.NewUsageError("proxy group %s not found", group)
 		}
+		selector = &proxy
+	} else {
+		selector = findDefaultGroup(proxyGroups(proxies))
 	}
 
 	if selector == nil {
@@ -194,49 +200,112 @@ func GetSelectorTable() (*SelectorTable, error) {
 
 	// --- select matched proxies
 	for _, name := range selector.All {
-		// proxy := proxies[name]
 		proxyList = append(proxyList, proxies[name])
 	}
 
 	// --- sort them
-	sort.Sort(proxyList)
+	sort.Stable(proxyList)
 
-	currentSelector = SelectorTable{
+	return &SelectorTable{
 		Selector: *selector,
 		Proxies:  proxyList,
+	}, nil
+}
+
+// withDelays returns s with delays (by proxy name) recorded as the latest
+// delay of its proxies, missing ones as failed, sorted again in one pass
+func (s SelectorTable) withDelays(delays map[string]int) SelectorTable {
+	proxies := make(ProxyList, 0, len(s.Proxies))
+	for _, proxy := range s.Proxies {
+		history := make([]api.History, len(proxy.History), len(proxy.History)+1)
+		copy(history, proxy.History)
+		proxy.History = append(history, api.History{Delay: delays[proxy.Name]})
+		proxies = append(proxies, proxy)
 	}
 
-	return &currentSelector, nil
+	sort.Stable(proxies)
+	return SelectorTable{Selector: s.Selector, Proxies: proxies}
 }
 
-func getProxyDelay(proxy Proxy) error {
-	server, err := defaultServer()
+// loadedSelectorTable returns the last rendered selector table
+// if it matches group, otherwise fetches it first
+// (e.g. when running a single command from the shell).
+func loadedSelectorTable(group string) (*SelectorTable, error) {
+	name, _, err := selectedServer()
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	req := common.MakeRequest(*server)
-	fail := common.HTTPError{}
-	resp, err := req.R().SetError(&fail).SetQueryParams(map[string]string{
-		"timeout": "3000",
-		// "url":     "https://www.google.com",
-		"url": "http://cp.cloudflare.com/generate_204",
-	}).Get("/proxies/" + proxy.NameEncoded() + "/delay")
-	if err != nil {
-		return fmt.Errorf("%s", text.FgRed.Sprint(err.Error()))
+	if selectorLoaded(name) && (group == "" || group == currentSelector.Selector.Name) {
+		return &currentSelector, nil
 	}
 
-	if resp.IsError() {
-		return fmt.Errorf("%s", text.FgRed.Sprint(fail.Message))
+	return GetSelectorTable(group)
+}
+
+// selectorLoaded reports whether currentSelector belongs to the server named server
+func selectorLoaded(server string) bool {
+	return currentSelector.Selector.Name != "" && currentSelectorServer == server
+}
+
+// resetSelector forgets the loaded table, e.g. when the server changes
+func resetSelector() {
+	currentSelector, currentSelectorServer = SelectorTable{}, ""
+}
+
+// proxyGroups returns every proxy group in the order of
+// the GLOBAL group (which follows the config file), then by name.
+func proxyGroups(proxies map[string]Proxy) []Proxy {
+	order := map[string]int{}
+	for i, name := range proxies[ProxyNameGlobal].All {
+		order[name] = i + 1
 	}
 
-	type body struct {
-		Delay int `json:"delay"`
+	var groups []Proxy
+	for _, proxy := range proxies {
+		if len(proxy.All) != 0 {
+			groups = append(groups, proxy)
+		}
 	}
-	var b body
-	_ = json.Unmarshal(resp.Body(), &b)
 
-	fmt.Println(proxy.Name, text.FgGreen.Sprintf("%vms", b.Delay))
+	rank := func(p Proxy) int {
+		if p.Name == ProxyNameGlobal {
+			return math.MaxInt
+		} else if i, ok := order[p.Name]; ok {
+			return i
+		}
+		return math.MaxInt - 1
+	}
+
+	sort.Slice(groups, func(i, j int) bool {
+		a, b := rank(groups[i]), rank(groups[j])
+		if a != b {
+			return a < b
+		}
+		return groups[i].Name < groups[j].Name
+	})
+
+	return groups
+}
+
+// findDefaultGroup returns the first selector other than GLOBAL
+func findDefaultGroup(groups []Proxy) *Proxy {
+	for _, proxy := range groups {
+		if proxy.Type == ProxyTypeSelector && proxy.Name != ProxyNameGlobal {
+			return &proxy
+		}
+	}
 
 	return nil
 }
+
+// indexOf returns the id of the proxy named name, -1 if not found
+func (s SelectorTable) indexOf(name string) int {
+	for i, proxy := range s.Proxies {
+		if proxy.Name == name {
+			return i
+		}
+	}
+
+	return -1
+}
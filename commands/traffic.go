@@ -0,0 +1,231 @@
+package commands
+
+import (
+	"fmt"
+	"os/signal"
+	"strings"
+	"time"
+
+	"github.com/yz3358/clash-ctl/common"
+	"github.com/yz3358/clash-ctl/utils"
+
+	"github.com/jedib0t/go-pretty/v6/progress"
+	"github.com/jedib0t/go-pretty/v6/table"
+	"github.com/jedib0t/go-pretty/v6/text"
+)
+
+var trafficFlags = []Flag{
+	{Name: "duration", Default: time.Duration(0), Usage: "exit with a summary after this long, 0 to run until interrupted"},
+	{Name: "window", Default: 60, Usage: "samples kept for the graph"},
+}
+
+// TrafficSample is the rate pushed on /traffic every second, in bytes
+type TrafficSample struct {
+	Up   int64 `json:"up"`
+	Down int64 `json:"down"`
+}
+
+// trafficStats keeps a rolling window of samples with the totals of the session
+type trafficStats struct {
+	window  int
+	samples []TrafficSample
+	count   int
+	peak    TrafficSample
+	total   TrafficSample
+}
+
+func newTrafficStats(window int) *trafficStats {
+	return &trafficStats{window: window}
+}
+
+func (s *trafficStats) add(t TrafficSample) {
+	s.samples = append(s.samples, t)
+	if len(s.samples) > s.window {
+		s.samples = s.samples[len(s.samples)-s.window:]
+	}
+
+	s.count++
+	s.total.Up += t.Up
+	s.total.Down += t.Down
+	if t.Up > s.peak.Up {
+		s.peak.Up = t.Up
+	}
+	if t.Down > s.peak.Down {
+		s.peak.Down = t.Down
+	}
+}
+
+func (s *trafficStats) current() TrafficSample {
+	if len(s.samples) == 0 {
+		return TrafficSample{}
+	}
+
+	return s.samples[len(s.samples)-1]
+}
+
+func (s *trafficStats) average() TrafficSample {
+	if s.count == 0 {
+		return TrafficSample{}
+	}
+
+	return TrafficSample{s.total.Up / int64(s.count), s.total.Down / int64(s.count)}
+}
+
+var sparkBars = []rune("▁▂▃▄▅▆▇█")
+
+// sparkline draws values scaled to their max, right aligned in width
+func sparkline(values []int64, width int) string {
+	var max int64
+	for _, v := range values {
+		if v > max {
+			max = v
+		}
+	}
+
+	b := strings.Builder{}
+	for i := len(values); i < width; i++ {
+		b.WriteRune(' ')
+	}
+	for _, v := range values {
+		level := 0
+		if max > 0 {
+			level = int(v * int64(len(sparkBars)-1) / max)
+		}
+		b.WriteRune(sparkBars[level])
+	}
+
+	return b.String()
+}
+
+// lines renders a graph line per direction
+func (s *trafficStats) lines() []string {
+	ups, downs := make([]int64, len(s.samples)), make([]int64, len(s.samples))
+	for i, t := range s.samples {
+		ups[i], downs[i] = t.Up, t.Down
+	}
+
+	current, avg := s.current(), s.average()
+	line := func(name string, values []int64, color text.Color, current, peak, avg, total int64) string {
+		return fmt.Sprintf("%-8s %s %12s  peak %12s  avg %12s  total %10s",
+			name, color.Sprint(sparkline(values, s.window)),
+			formatRate(float64(current)), formatRate(float64(peak)), formatRate(float64(avg)), progress.FormatBytes(total))
+	}
+
+	return []string{
+		line("Download", downs, text.FgGreen, current.Down, s.peak.Down, avg.Down, s.total.Down),
+		line("Upload", ups, text.FgCyan, current.Up, s.peak.Up, avg.Up, s.total.Up),
+	}
+}
+
+// showTraffic graphs the traffic until interrupted or the duration
+// elapses, then shows a summary, as `traffic [flags]`
+func showTraffic(a *Args) error {
+	duration, window := a.Duration("duration"), a.Int("window")
+	if duration < 0 || window < 1 {
+		return common.NewUsageError("duration should not be negative, window should be at least 1")
+	}
+
+	server, err := defaultServer()
+	if err != nil {
+		return err
+	}
+
+	conn, err := common.MakeWebsocket(*server, "/traffic")
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	samples := make(chan TrafficSample)
+	errCh := make(chan error, 1)
+	done := make(chan struct{})
+	defer close(done)
+
+	go func() {
+		for {
+			sample := TrafficSample{}
+			if err := conn.ReadJSON(&sample); err != nil {
+				errCh <- err
+				return
+			}
+
+			select {
+			case samples <- sample:
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	sigCh := utils.Signal()
+	defer signal.Stop(sigCh)
+
+	var timeout <-chan time.Time
+	if duration > 0 {
+		timer := time.NewTimer(duration)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	stats, start, drawn := newTrafficStats(window), time.Now(), 0
+	for {
+		select {
+		case <-sigCh:
+			fmt.Println()
+			return stats.summary(time.Since(start))
+		case <-timeout:
+			return stats.summary(time.Since(start))
+		case err := <-errCh:
+			return err
+		case sample := <-samples:
+			stats.add(sample)
+			if Output != common.FormatTable {
+				continue
+			}
+
+			// redraw the graph in place
+			if drawn > 0 {
+				fmt.Printf("\033[%dA", drawn)
+			}
+			lines := stats.lines()
+			for _, l := range lines {
+				fmt.Printf("\033[2K\r%s\n", l)
+			}
+			drawn = len(lines)
+		}
+	}
+}
+
+// summary shows the stats of a session which lasted elapsed
+func (s *trafficStats) summary(elapsed time.Duration) error {
+	type entry struct {
+		Direction string `json:"direction"`
+		Current   int64  `json:"current"`
+		Peak      int64  `json:"peak"`
+		Average   int64  `json:"average"`
+		Total     int64  `json:"total"`
+	}
+
+	current, avg := s.current(), s.average()
+	entries := []entry{
+		{"download", current.Down, s.peak.Down, avg.Down, s.total.Down},
+		{"upload", current.Up, s.peak.Up, avg.Up, s.total.Up},
+	}
+
+	rows := []table.Row{}
+	for _, e := range entries {
+		rows = append(rows, table.Row{
+			e.Direction, formatRate(float64(e.Current)), formatRate(float64(e.Peak)),
+			formatRate(float64(e.Average)), progress.FormatBytes(e.Total),
+		})
+	}
+
+	if err := printResult(entries, table.Row{"Direction", "Current", "Peak", "Average", "Total"}, rows, table.StyleRounded); err != nil {
+		return err
+	}
+
+	if Output == common.FormatTable {
+		fmt.Printf("%d samples over %s\n", s.count, elapsed.Round(time.Second))
+	}
+	return nil
+}
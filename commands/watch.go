@@ -0,0 +1,300 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"time"
+
+	"github.com/yz3358/clash-ctl/api"
+	"github.com/yz3358/clash-ctl/common"
+	"github.com/yz3358/clash-ctl/utils"
+
+	"github.com/jedib0t/go-pretty/v6/table"
+	"github.com/jedib0t/go-pretty/v6/text"
+)
+
+// watchFlags are the flags of `watch` besides
+// benchFlags (but rounds) and autoFlags
+var watchFlags = []Flag{
+	{Name: "interval", Default: 30 * time.Second, Usage: "time between two checks"},
+	{Name: "failures", Default: 3, Usage: "consecutive failures of the selected proxy before failing over"},
+	{Name: "hysteresis", Default: 3, Usage: "consecutive checks a candidate must pass to be picked"},
+	{Name: "cooldown", Default: 5 * time.Minute, Usage: "min time between two switches of a group"},
+	{Name: "log", Default: "", Usage: "also append every switch to the file"},
+}
+
+func watchCommandFlags() []Flag {
+	flags := append([]Flag{}, watchFlags...)
+	for _, f := range benchFlags {
+		if f.Name != "rounds" {
+			flags = append(flags, f)
+		}
+	}
+
+	return append(flags, autoFlags...)
+}
+
+// watchState is the health of the selected proxy of a group
+type watchState struct {
+	failures   int
+	lastSwitch time.Time
+	// passes counts the consecutive checks each candidate passed
+	// since the selected proxy started failing
+	passes map[string]int
+}
+
+// switchEvent is a failover made by the watchdog
+type switchEvent struct {
+	Time  time.Time `json:"time"`
+	Group string    `json:"group"`
+	From  string    `json:"from"`
+	To    string    `json:"to"`
+	Delay int       `json:"delay"`
+}
+
+// watchdog tests the selected proxy of groups, and switches a group
+// to its best healthy proxy after consecutive failures
+type watchdog struct {
+	groups     []string
+	states     map[string]*watchState
+	opts       BenchOptions
+	policy     AutoPolicy
+	failures   int
+	hysteresis int
+	cooldown   time.Duration
+	// log, if not nil, receives a line per switch
+	log      io.Writer
+	switches []switchEvent
+}
+
+func (w *watchdog) printf(format string, a ...any) {
+	fmt.Printf("%s %s\n", time.Now().Format("15:04:05"), fmt.Sprintf(format, a...))
+}
+
+// check tests every group once
+func (w *watchdog) check(client *api.Client) {
+	for _, group := range w.groups {
+		if err := w.checkGroup(client, group); err != nil {
+			w.printf("%s: %s", group, text.FgRed.Sprint(err.Error()))
+		}
+	}
+}
+
+func (w *watchdog) checkGroup(client *api.Client, group string) error {
+	g, err := client.Proxy(context.Background(), group)
+	if err != nil {
+		return err
+	}
+
+	state, ok := w.states[group]
+	if !ok {
+		state = &watchState{}
+		w.states[group] = state
+	}
+
+	delay, err := client.ProxyDelay(context.Background(), g.Now, w.opts.URL, w.opts.Timeout)
+	if err == nil && delay > 0 {
+		if state.failures > 0 {
+			w.printf("%s: %s recovered %s", group, g.Now, formatDelay(delay))
+		} else {
+			w.printf("%s: %s %s", group, g.Now, formatDelay(delay))
+		}
+		state.failures, state.passes = 0, nil
+		return nil
+	}
+
+	state.failures++
+	w.printf("%s: %s %s (%d/%d)", group, g.Now, text.FgRed.Sprint("failed"), state.failures, w.failures)
+
+	// candidates are tested at every failed check, so they
+	// can pass enough checks in a row by the time to switch
+	if err := w.testCandidates(group, g.Now, state); err != nil {
+		return err
+	}
+
+	if state.failures < w.failures {
+		return nil
+	}
+
+	if left := w.cooldown - time.Since(state.lastSwitch); left > 0 {
+		w.printf("%s: cooling down, %s before the next switch", group, left.Round(time.Second))
+		return nil
+	}
+
+	return w.failover(group, g.Now, state)
+}
+
+// testCandidates benchmarks group once, counting the consecutive checks
+// passed by each proxy the policy accepts, loss included, other than from
+func (w *watchdog) testCandidates(group, from string, state *watchState) error {
+	s, err := GetSelectorTable(group)
+	if err != nil {
+		state.passes = nil
+		return err
+	}
+
+	results, err := s.Bench(w.opts)
+	if err != nil {
+		state.passes = nil
+		return err
+	}
+
+	currentSelector = s.withDelays(w.policy.delays(results))
+
+	passes := map[string]int{}
+	for _, proxy := range currentSelector.Proxies {
+		if proxy.Name != from && w.policy.Allow(proxy) {
+			passes[proxy.Name] = state.passes[proxy.Name] + 1
+		}
+	}
+	state.passes = passes
+
+	return nil
+}
+
+// failover switches group to the fastest proxy of the last check
+// which passed the latest w.hysteresis checks in a row
+func (w *watchdog) failover(group, from string, state *watchState) error {
+	if len(state.passes) == 0 {
+		w.printf("%s: %s", group, text.FgRed.Sprint("no healthy alternative"))
+		return nil
+	}
+
+	// proxies are sorted by the delays of the last check
+	for id, proxy := range currentSelector.Proxies {
+		if state.passes[proxy.Name] < w.hysteresis {
+			continue
+		}
+
+		if err := currentSelector.Use(id); err != nil {
+			return err
+		}
+		currentSelector.Selector.Now = proxy.Name
+
+		event := switchEvent{time.Now(), group, from, proxy.Name, proxy.LastestDelay()}
+		w.switches = append(w.switches, event)
+		state.failures, state.lastSwitch, state.passes = 0, event.Time, nil
+
+		w.printf("%s: %s --> %s %s", group, from, text.FgGreen.Sprint(proxy.Name), formatDelay(event.Delay))
+		if w.log != nil {
+			line := fmt.Sprintf("%s %s: %s --> %s (%dms)\n", event.Time.Format(time.RFC3339), group, from, proxy.Name, event.Delay)
+			if _, err := io.WriteString(w.log, line); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	w.printf("%s: no alternative passed %d checks in a row yet", group, w.hysteresis)
+	return nil
+}
+
+// watchGroups runs the watchdog on groups until interrupted,
+// as `watch [group...] [flags]`
+func watchGroups(a *Args) error {
+	server, err := defaultServer()
+	if err != nil {
+		return err
+	}
+
+	policy, err := autoPolicy(*server, a)
+	if err != nil {
+		return err
+	}
+
+	w := &watchdog{
+		groups:     a.Positional,
+		states:     map[string]*watchState{},
+		opts:       benchOptions(*server, a),
+		policy:     *policy,
+		failures:   a.Int("failures"),
+		hysteresis: a.Int("hysteresis"),
+		cooldown:   a.Duration("cooldown"),
+	}
+	// a check tests every candidate once, hysteresis counting the checks
+	w.opts.Rounds = 1
+
+	interval := a.Duration("interval")
+	if interval < time.Second || w.failures < 1 || w.hysteresis < 1 {
+		return common.NewUsageError("interval should be at least 1s, failures and hysteresis at least 1")
+	}
+
+	client := newClient(*server)
+	if len(w.groups) == 0 {
+		s, err := GetSelectorTable("")
+		if err != nil {
+			return err
+		}
+		w.groups = []string{s.Selector.Name}
+	}
+
+	for _, group := range w.groups {
+		g, err := client.Proxy(context.Background(), group)
+		if err != nil {
+			return err
+		}
+		if g.Type != ProxyTypeSelector {
+			return common.NewUsageError("%s is a %s group, only selectors can be switched", group, g.Type)
+		}
+	}
+
+	if path := a.String("log"); path != "" {
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w.log = f
+	}
+
+	sigCh := utils.Signal()
+	defer signal.Stop(sigCh)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	w.check(client)
+	for {
+		select {
+		case <-sigCh:
+			fmt.Println()
+			return w.summary()
+		case <-ticker.C:
+			w.check(client)
+		}
+	}
+}
+
+// summary shows the switches made by the watchdog
+func (w *watchdog) summary() error {
+	if len(w.switches) == 0 && Output == common.FormatTable {
+		fmt.Println("no switch made")
+		return nil
+	}
+
+	rows := []table.Row{}
+	for _, e := range w.switches {
+		rows = append(rows, table.Row{e.Time.Format("15:04:05"), e.Group, e.From, e.To, formatDelay(e.Delay)})
+	}
+
+	return printResult(w.switches, table.Row{"Time", "Group", "From", "To", "Delay"}, rows, table.StyleRounded)
+}
+
+// WatchResolver completes the groups of `watch`
+func WatchResolver(params []string) []common.Node {
+	proxies, err := getCachedProxies()
+	if err != nil {
+		return []common.Node{}
+	}
+
+	nodes := []common.Node{}
+	for _, n := range groupNodes(proxies) {
+		if proxies[n.Text].Type == ProxyTypeSelector {
+			nodes = append(nodes, n)
+		}
+	}
+	return nodes
+}
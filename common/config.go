@@ -19,6 +19,18 @@ type Server struct {
 	Port   string `toml:"port"`
 	Secret string `toml:"secret"`
 	HTTPS  bool   `toml:"https"`
+	// Group is the default proxy group of `proxy ls|use|bench`
+	Group string `toml:"group,omitempty"`
+	// defaults of `proxy bench`, unset ones use the built-in defaults
+	BenchURL         string `toml:"bench-url,omitempty"`
+	BenchTimeout     int    `toml:"bench-timeout,omitempty"` // in ms
+	BenchRounds      int    `toml:"bench-rounds,omitempty"`
+	BenchConcurrency int    `toml:"bench-concurrency,omitempty"`
+	// policy of `proxy auto`, name patterns are regexes
+	AutoInclude  string `toml:"auto-include,omitempty"`
+	AutoExclude  string `toml:"auto-exclude,omitempty"`
+	AutoMaxDelay int    `toml:"auto-max-delay,omitempty"` // in ms
+	AutoMaxLoss  int    `toml:"auto-max-loss,omitempty"`  // in percent
 }
 
 func (s Server) URL() url.URL {
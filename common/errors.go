@@ -0,0 +1,17 @@
+package common
+
+import "fmt"
+
+// UsageError reports a command invoked with missing or malformed arguments.
+type UsageError struct {
+	Message string
+}
+
+func (e *UsageError) Error() string {
+	return e.Message
+}
+
+// NewUsageError formats a UsageError according to a format specifier.
+func NewUsageError(format string, a ...any) error {
+	return &UsageError{Message: fmt.Sprintf(format, a...)}
+}
@@ -0,0 +1,25 @@
+package common
+
+// Format is the way commands print their results
+type Format string
+
+const (
+	FormatTable Format = "table"
+	FormatJSON  Format = "json"
+	FormatYAML  Format = "yaml"
+	FormatTSV   Format = "tsv"
+	FormatPlain Format = "plain"
+)
+
+// Formats lists every supported output format
+var Formats = []Format{FormatTable, FormatJSON, FormatYAML, FormatTSV, FormatPlain}
+
+func ParseFormat(s string) (Format, error) {
+	for _, f := range Formats {
+		if string(f) == s {
+			return f, nil
+		}
+	}
+
+	return "", NewUsageError("unknown output format %s, should be one of %v", s, Formats)
+}
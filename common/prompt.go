@@ -2,11 +2,10 @@ package common
 
 import "github.com/manifoldco/promptui"
 
+// Node is a suggestion of the completer
 type Node struct {
 	Text        string
 	Description string
-	Resolver    func(params []string) (int, []Node)
-	Children    []Node
 }
 
 type Field struct {
@@ -3,32 +3,17 @@ package common
 import (
 	"fmt"
 	"net/http"
+	"strings"
 	"time"
 
-	"github.com/go-resty/resty/v2"
 	"github.com/gorilla/websocket"
 )
 
-type HTTPError struct {
-	Message string `json:"message"`
-}
-
-// MakeRequest compose a resty.Client
-// that send request to given Server
-func MakeRequest(s Server) *resty.Client {
-	u := s.URL()
-	client := resty.New().SetBaseURL(u.String())
-
-	if s.Secret != "" {
-		client.SetHeader("Authorization", fmt.Sprintf("Bearer %s", s.Secret))
-	}
-
-	return client
-}
-
+// MakeWebsocket dials the websocket at path of given Server,
+// path may carry a query string (e.g. /logs?level=info)
 func MakeWebsocket(s Server, path string) (*websocket.Conn, error) {
 	u := s.WebsocketURL()
-	u.Path = path
+	u.Path, u.RawQuery, _ = strings.Cut(path, "?")
 
 	header := http.Header{}
 	if s.Secret != "" {
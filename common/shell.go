@@ -0,0 +1,125 @@
+package common
+
+import (
+	"strings"
+	"unicode"
+)
+
+// SplitArgs splits a command line into arguments like a shell does:
+// whitespace separates arguments, single quotes keep everything literally,
+// double quotes keep everything but backslash escaped `"` and `\`,
+// and a backslash outside quotes escapes the next character
+func SplitArgs(line string) ([]string, error) {
+	words, last, inWord, _, quote := split(line)
+	if quote != 0 {
+		return nil, NewUsageError("unterminated quote %c", quote)
+	}
+
+	if inWord {
+		words = append(words, last)
+	}
+	return words, nil
+}
+
+// SplitPartial splits a command line being typed, the last word is
+// the one under the cursor (empty after whitespace) and starts at the returned offset.
+// Unterminated quotes are allowed
+func SplitPartial(line string) ([]string, int) {
+	words, last, _, start, _ := split(line)
+	return append(words, last), start
+}
+
+// Quote returns s as a single argument of SplitArgs,
+// wrapped in double quotes when it contains special characters
+func Quote(s string) string {
+	if s != "" && strings.IndexFunc(s, isSpecial) < 0 {
+		return s
+	}
+
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	s = strings.ReplaceAll(s, `"`, `\"`)
+	return `"` + s + `"`
+}
+
+// Escape returns s as a single argument of SplitArgs,
+// with a backslash before every special character instead of quotes
+func Escape(s string) string {
+	if s == "" {
+		return `""`
+	}
+
+	b := strings.Builder{}
+	for _, r := range s {
+		if isSpecial(r) {
+			b.WriteRune('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// isSpecial reports whether r needs quoting or escaping, any unicode
+// whitespace (e.g. U+3000 typed by CJK input methods) separating arguments
+func isSpecial(r rune) bool {
+	return unicode.IsSpace(r) || r == '\'' || r == '"' || r == '\\'
+}
+
+func split(line string) (words []string, last string, inWord bool, start int, quote rune) {
+	word := strings.Builder{}
+	escaped := false
+	start = len(line)
+
+	begin := func(i int) {
+		if !inWord {
+			inWord, start = true, i
+		}
+	}
+
+	for i, r := range line {
+		switch {
+		case escaped:
+			// only `"` and `\` are escaped in double quotes
+			if quote == '"' && r != '"' && r != '\\' {
+				word.WriteRune('\\')
+			}
+			word.WriteRune(r)
+			escaped = false
+		case quote == '\'':
+			if r == '\'' {
+				quote = 0
+			} else {
+				word.WriteRune(r)
+			}
+		case quote == '"':
+			if r == '"' {
+				quote = 0
+			} else if r == '\\' {
+				escaped = true
+			} else {
+				word.WriteRune(r)
+			}
+		case r == '\\':
+			begin(i)
+			escaped = true
+		case r == '\'' || r == '"':
+			begin(i)
+			quote = r
+		case unicode.IsSpace(r):
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord, start = false, len(line)
+			}
+		default:
+			begin(i)
+			word.WriteRune(r)
+		}
+	}
+
+	// keep a dangling backslash as is
+	if escaped {
+		word.WriteRune('\\')
+	}
+
+	return words, word.String(), inWord, start, quote
+}
@@ -0,0 +1,76 @@
+package common
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSplitArgs(t *testing.T) {
+	tests := []struct {
+		in       string
+		expected []string
+	}{
+		{"", nil},
+		{"  proxy   ls  ", []string{"proxy", "ls"}},
+		{`proxy use "HK 01 | Premium"`, []string{"proxy", "use", "HK 01 | Premium"}},
+		{`proxy use 'HK 01 | "Premium"'`, []string{"proxy", "use", `HK 01 | "Premium"`}},
+		{`proxy use HK\ 01`, []string{"proxy", "use", "HK 01"}},
+		{`a "b \"c\" \d \\"`, []string{"a", `b "c" \d \`}},
+		{`a "" b`, []string{"a", "", "b"}},
+		{`a"b c"d`, []string{"ab cd"}},
+		{`a\`, []string{`a\`}},
+	}
+
+	for _, tt := range tests {
+		got, err := SplitArgs(tt.in)
+		if err != nil {
+			t.Errorf("%q: unexpected error %v", tt.in, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.expected) {
+			t.Errorf("%q: expected %q, got %q", tt.in, tt.expected, got)
+		}
+	}
+
+	if _, err := SplitArgs(`proxy use "HK 01`); err == nil {
+		t.Error("expected error of unterminated quote")
+	}
+}
+
+func TestSplitPartial(t *testing.T) {
+	tests := []struct {
+		in       string
+		expected []string
+		start    int
+	}{
+		{"", []string{""}, 0},
+		{"proxy ", []string{"proxy", ""}, 6},
+		{"proxy l", []string{"proxy", "l"}, 6},
+		{`proxy use "HK 0`, []string{"proxy", "use", "HK 0"}, 10},
+	}
+
+	for _, tt := range tests {
+		got, start := SplitPartial(tt.in)
+		if !reflect.DeepEqual(got, tt.expected) || start != tt.start {
+			t.Errorf("%q: expected %q at %d, got %q at %d", tt.in, tt.expected, tt.start, got, start)
+		}
+	}
+}
+
+func TestQuote(t *testing.T) {
+	for _, s := range []string{"HK01", "HK 01 | Premium", `a "b"`, `c\d`, "it's", "", "HK\u300001", "JP\u00a002"} {
+		got, err := SplitArgs(Quote(s))
+		if err != nil || len(got) != 1 || got[0] != s {
+			t.Errorf("%q: quoted as %s, split back to %q (%v)", s, Quote(s), got, err)
+		}
+
+		got, err = SplitArgs(Escape(s))
+		if err != nil || len(got) != 1 || got[0] != s {
+			t.Errorf("%q: escaped as %s, split back to %q (%v)", s, Escape(s), got, err)
+		}
+	}
+
+	if got := Quote("HK01"); got != "HK01" {
+		t.Errorf("expected plain name unquoted, got %s", got)
+	}
+}
@@ -9,6 +9,7 @@ require (
 	github.com/jedib0t/go-pretty/v6 v6.3.1
 	github.com/manifoldco/promptui v0.9.0
 	github.com/pelletier/go-toml/v2 v2.0.1
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
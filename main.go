@@ -1,137 +1,141 @@
 package main
 
 import (
+	"errors"
+	"flag"
 	"fmt"
+	"net"
 	"os"
 	"strings"
 
+	"github.com/yz3358/clash-ctl/api"
 	"github.com/yz3358/clash-ctl/commands"
 	"github.com/yz3358/clash-ctl/common"
 
 	"github.com/c-bata/go-prompt"
+	"github.com/gorilla/websocket"
 	"github.com/jedib0t/go-pretty/v6/text"
 )
 
-var root = []common.Node{
-	{
-		Text: "proxy", Description: "manage remote clash proxy",
-		Children: []common.Node{
-			// {
-			// 	Text: "set", Description: "(set by name) select a proxy from a group",
-			// 	Resolver: commands.ProxySetResolver,
-			// },
-			{
-				Text: "ls", Description: "list avaliable proxies with ids",
-			},
-			{
-				Text: "use", Description: "use proxy by id",
-			},
-			{
-				Text: "bench", Description: "show benchmark",
-			},
-		},
-	},
-	{
-		Text: "mode", Description: "manage proxy mode",
-		Children: []common.Node{
-			{Text: commands.ModeRule, Description: "set as mode -" + commands.ModeRule},
-			{Text: commands.ModeGlobal, Description: "set as mode -" + commands.ModeGlobal},
-			{Text: commands.ModeDirect, Description: "set as mode -" + commands.ModeDirect},
-		},
-	},
-	{Text: "now", Description: "show selected clash server"},
-	{Text: "ping", Description: "check clash servers alive"},
-	{Text: "traffic", Description: "get clash traffic"},
-	{Text: "connections", Description: "get clash all connections"},
-	{
-		Text: "server", Description: "manage remote clash server",
-		Children: []common.Node{
-			{Text: "ls", Description: "list all server"},
-			{Text: "add", Description: "add new server"},
-			{Text: "rm", Description: "rm a server", Resolver: commands.UseServerResolver},
-		},
-	},
-	// {original `proxy` cmd }
-	{
-		Text: "use", Description: "change selected clash server",
-		Resolver: commands.UseServerResolver,
-	},
-}
+// process exit codes of the non-interactive mode
+const (
+	exitOK      = 0
+	exitFailure = 1
+	exitUsage   = 2
+	exitNetwork = 3
+	exitAPI     = 4
+)
 
 func executor(in string) {
-	in = strings.TrimSpace(in)
-
-	blocks := strings.Split(in, " ")
-	switch blocks[0] {
-	case "exit":
-		fmt.Println("Bye!")
-		os.Exit(0)
-	case "server":
-		commands.HandleServerCommand(blocks[1:])
-	case "now", "use", "ping":
-		commands.HandleMiscCommand(blocks)
-	case "traffic", "connections":
-		commands.HandleCommonCommand(blocks)
-	case "proxy":
-		commands.HandleProxyCommand(blocks[1:])
-	case "mode":
-		commands.HandleModeCommand(blocks[1:])
+	args, err := common.SplitArgs(in)
+	if err == nil && len(args) == 0 {
+		return
+	}
+
+	if err == nil {
+		err = commands.Execute(args)
+	}
+
+	if err != nil {
+		fmt.Println(text.FgRed.Sprint(err.Error()))
 	}
 }
 
+// exitCode maps the error returned by a command to a process exit code
+func exitCode(err error) int {
+	var (
+		usageErr *common.UsageError
+		apiErr   *api.Error
+		netErr   net.Error
+	)
+
+	switch {
+	case err == nil:
+		return exitOK
+	case errors.As(err, &usageErr):
+		return exitUsage
+	case errors.As(err, &apiErr):
+		return exitAPI
+	case errors.As(err, &netErr), errors.Is(err, websocket.ErrBadHandshake):
+		return exitNetwork
+	default:
+		return exitFailure
+	}
+}
+
+// wordSeparator is what the prompt splits the word to complete on, the
+// ASCII whitespace only as it slices the line by bytes around separators
+const wordSeparator = " \t\n\v\f\r"
+
 func completer(in prompt.Document) []prompt.Suggest {
-	args := strings.Split(in.TextBeforeCursor(), " ")
-	n := root
-	prefixIdx := 0
-
-outside:
-	for i := 0; i < len(args)-1; i++ {
-		var next []common.Node
-		keyword := args[prefixIdx]
-		if keyword == "" {
-			break
-		}
+	before := in.TextBeforeCursor()
+	args, start := common.SplitPartial(before)
+	current := strings.ToLower(args[len(args)-1])
 
-		for _, nd := range n {
-			if nd.Text == keyword {
-				if nd.Resolver != nil {
-					var step int
-					step, n = nd.Resolver(args[prefixIdx+1:])
-					prefixIdx += step
-					break outside
-				} else if len(nd.Children) != 0 {
-					next = nd.Children
-				}
-				break
-			}
+	// the prompt only replaces the text after the last wordSeparator,
+	// so a quoted or escaped word being typed keeps what is before it,
+	// and a word after other whitespace (e.g. U+3000) keeps that whitespace
+	typed, word := before[start:], in.GetWordBeforeCursorUntilSeparator(wordSeparator)
+	var kept, prefix string
+	if len(word) <= len(typed) {
+		kept = typed[:len(typed)-len(word)]
+	} else {
+		prefix = word[:len(word)-len(typed)]
+	}
+	escaped := strings.Contains(typed, `\`) && !strings.HasPrefix(typed, "'") && !strings.HasPrefix(typed, `"`)
+	if escaped && (len(typed)-len(strings.TrimRight(typed, `\`)))%2 == 1 {
+		// a dangling backslash escapes what comes next
+		current = strings.TrimSuffix(current, `\`)
+	}
+
+	var suggestions []prompt.Suggest
+	for _, sg := range commands.Complete(args) {
+		if !strings.HasPrefix(strings.ToLower(sg.Text), current) {
+			continue
 		}
 
-		prefixIdx++
-		if next == nil {
-			n = nil
-			break
+		quoted := common.Quote(sg.Text)
+		if strings.HasPrefix(typed, "'") && !strings.Contains(sg.Text, "'") {
+			quoted = "'" + sg.Text + "'"
+		} else if escaped {
+			quoted = common.Escape(sg.Text)
 		}
 
-		n = next
-	}
+		if !strings.HasPrefix(quoted, kept) {
+			continue
+		}
 
-	var suggestions []prompt.Suggest
-	for _, sg := range n {
-		suggestion := prompt.Suggest{Text: sg.Text, Description: sg.Description}
-		suggestions = append(suggestions, suggestion)
+		suggestions = append(suggestions, prompt.Suggest{Text: prefix + quoted[len(kept):], Description: sg.Description})
 	}
 
-	return prompt.FilterHasPrefix(
-		suggestions,
-		args[prefixIdx],
-		true,
-	)
+	return suggestions
 }
 
 func main() {
 	if err := common.Init(); err != nil {
 		fmt.Println(text.FgRed.Sprint(err.Error()))
-		return
+		os.Exit(exitFailure)
+	}
+
+	var output string
+	flag.StringVar(&output, "o", string(common.FormatTable), "output format: table, json, yaml, tsv or plain")
+	flag.StringVar(&output, "output", string(common.FormatTable), "output format: table, json, yaml, tsv or plain")
+	flag.Parse()
+
+	format, err := common.ParseFormat(output)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(exitUsage)
+	}
+	commands.Output = format
+
+	// run a single command and exit when arguments are given
+	if flag.NArg() > 0 {
+		err := commands.Execute(flag.Args())
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+		}
+		os.Exit(exitCode(err))
 	}
 
 	p := prompt.New(
@@ -140,6 +144,7 @@ func main() {
 		prompt.OptionPrefix(">>> "),
 		prompt.OptionTitle("clash-ctl"),
 		prompt.OptionCompletionOnDown(),
+		prompt.OptionCompletionWordSeparator(wordSeparator),
 		prompt.OptionShowCompletionAtStart(),
 	)
 	p.Run()
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/yz3358/clash-ctl/api/apitest"
+	"github.com/yz3358/clash-ctl/commands"
+	"github.com/yz3358/clash-ctl/common"
+
+	"github.com/c-bata/go-prompt"
+)
+
+func suggest(in string) []string {
+	buf := prompt.NewBuffer()
+	buf.InsertText(in, false, true)
+
+	var texts []string
+	for _, s := range completer(*buf.Document()) {
+		texts = append(texts, s.Text)
+	}
+	return texts
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+func TestCompleter(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	if err := common.Init(); err != nil {
+		t.Fatal(err)
+	}
+
+	srv := apitest.NewServer()
+	defer srv.Close()
+
+	cfg := &common.Config{
+		Servers:  map[string]common.Server{"fake": {Host: srv.Host(), Port: srv.Port()}},
+		Selected: "fake",
+	}
+	if err := common.SaveCfg(cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		in       string
+		expected []string
+		missing  []string
+	}{
+		{in: "", expected: []string{"proxy", "mode", "server", "use"}},
+		{in: "pro", expected: []string{"proxy", "provider"}, missing: []string{"mode"}},
+		{in: "proxy ", expected: []string{"set", "groups", "ls", "use", "bench"}},
+		{in: "proxy set ", expected: []string{"Proxy", "GLOBAL"}},
+		{in: "proxy set Proxy ", expected: []string{`"HK 01"`, `"JP 02"`}},
+		{in: "proxy use ", expected: []string{`"HK 01"`, "Proxy"}},
+		{in: "proxy use Proxy u", expected: []string{`"US 03"`}, missing: []string{"Proxy"}},
+		{in: "proxy set Proxy j", expected: []string{`"JP 02"`}, missing: []string{`"HK 01"`}},
+		{in: `proxy set Proxy "HK 0`, expected: []string{`01"`}, missing: []string{`"HK 01"`}},
+		{in: "proxy set Proxy 'JP", expected: []string{`'JP 02'`}},
+		{in: "proxy  ls  ", expected: []string{"Proxy", "GLOBAL"}, missing: []string{`"HK 01"`}},
+		{in: "mode ", expected: []string{"rule", "global", "direct"}},
+		{in: "mode g", expected: []string{"global"}, missing: []string{"rule"}},
+		{in: "use ", expected: []string{"fake"}},
+		{in: "server rm f", expected: []string{"fake"}},
+		{in: "server ", expected: []string{"ls", "add", "rm", "use"}},
+		{in: "server use ", expected: []string{"fake"}},
+		{in: "connections watch --", expected: []string{"--interval", "--sort", "--limit"}},
+		{in: "connections watch --sort ", expected: []string{"rate", "host"}},
+		{in: "ruleset update -", expected: []string{"--all"}},
+		{in: "help ", expected: []string{"proxy", "server"}},
+		{in: "config set log-level ", expected: []string{"debug", "info", "warning", "error"}},
+		{in: "unknown ", missing: []string{"proxy"}},
+		// whitespace the prompt does not split words on
+		{in: "proxy\u3000", expected: []string{"proxy\u3000set", "proxy\u3000ls"}},
+		{in: "proxy\u00a0", expected: []string{"proxy\u00a0set"}},
+		{in: "proxy\t", expected: []string{"set", "ls"}},
+		{in: "proxy\u3000l", expected: []string{"proxy\u3000ls"}, missing: []string{"set"}},
+		{in: "proxy set Proxy\u3000", expected: []string{"Proxy\u3000\"HK 01\""}},
+		// backslash escaped spaces complete in the same form
+		{in: `proxy set Proxy HK\ 0`, expected: []string{"01"}, missing: []string{`"HK 01"`}},
+		{in: `proxy set Proxy HK\`, expected: []string{`HK\ 01`}},
+	}
+
+	for _, tt := range tests {
+		got := suggest(tt.in)
+		for _, s := range tt.expected {
+			if !contains(got, s) {
+				t.Errorf("%q: expected suggestion %q in %v", tt.in, s, got)
+			}
+		}
+		for _, s := range tt.missing {
+			if contains(got, s) {
+				t.Errorf("%q: unexpected suggestion %q in %v", tt.in, s, got)
+			}
+		}
+	}
+}
+
+func TestExitCode(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	if err := common.Init(); err != nil {
+		t.Fatal(err)
+	}
+
+	srv := apitest.NewServer()
+	defer srv.Close()
+
+	cfg := &common.Config{
+		Servers: map[string]common.Server{
+			"fake": {Host: srv.Host(), Port: srv.Port()},
+			"down": {Host: "127.0.0.1", Port: "1"},
+		},
+		Selected: "fake",
+	}
+	if err := common.SaveCfg(cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		args []string
+		code int
+	}{
+		{[]string{"unknown"}, exitUsage},
+		{[]string{"proxy"}, exitUsage},
+		{[]string{"proxy", "bogus"}, exitUsage},
+		{[]string{"connections", "bogus"}, exitUsage},
+		{[]string{"proxy", "set", "Proxy"}, exitUsage},
+		{[]string{"rules", "ls", "--page", "x"}, exitUsage},
+		{[]string{"mode", "bogus"}, exitUsage},
+		{[]string{"proxy", "set", "Proxy", "nowhere"}, exitAPI},
+		{[]string{"proxy", "set", "Proxy", "JP 02"}, exitOK},
+		{[]string{"config", "set", "ipv6", "true"}, exitOK},
+		{[]string{"server", "use", "down"}, exitOK},
+		{[]string{"mode"}, exitNetwork},
+	}
+
+	for _, tt := range tests {
+		if code := exitCode(commands.Execute(tt.args)); code != tt.code {
+			t.Errorf("%v: expected exit code %d, got %d", tt.args, tt.code, code)
+		}
+	}
+}
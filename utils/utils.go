@@ -6,8 +6,9 @@ import (
 	"syscall"
 )
 
+// Signal notifies the returned channel when the process is interrupted or terminated
 func Signal() chan os.Signal {
 	sigCh := make(chan os.Signal, 1)
-	signal.Notify(sigCh, syscall.SIGINT)
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
 	return sigCh
 }